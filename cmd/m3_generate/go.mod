@@ -31,6 +31,7 @@ require (
 	github.com/m3db/stackmurmur3 v0.0.0-20171110233611-744c0229c12e // indirect
 	github.com/m3db/vellum v0.0.0-20190111185746-e766292d14de // indirect
 	github.com/mauricelam/genny v0.0.0-20190320071652-0800202903e5 // indirect
+	github.com/prometheus/prometheus v1.8.2-0.20200201073137-0e912faf4f52
 	github.com/satori/go.uuid v1.2.0
 	github.com/sergi/go-diff v1.1.0 // indirect
 	github.com/tmc/grpc-websocket-proxy v0.0.0-20200122045848-3419fae592fc // indirect
@@ -47,6 +48,9 @@ require (
 	gopkg.in/vmihailenco/msgpack.v2 v2.9.1 // indirect
 )
 
+// Build against the pkg module in this repository
+replace github.com/chronosphereiox/high_cardinality_microbenchmark/pkg => ../../pkg
+
 // Use some specific dependencies
 replace github.com/apache/thrift/lib/go/thrift => github.com/m3dbx/thrift/lib/go/thrift v0.0.0-20200106002022-da72b4507a76
 
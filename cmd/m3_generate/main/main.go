@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	_ "net/http/pprof" // pprof: for debug listen server if configured
@@ -21,6 +23,7 @@ import (
 	"github.com/m3db/m3/src/query/models"
 	"github.com/m3db/m3/src/x/ident"
 	"github.com/m3db/m3/src/x/instrument"
+	"github.com/prometheus/prometheus/prompb"
 	uuid "github.com/satori/go.uuid"
 	"go.uber.org/zap"
 )
@@ -33,6 +36,11 @@ func main() {
 	var (
 		flagCardinality = flag.Int("cardinality", 5000000, "cardinality to generate")
 		flagDir         = flag.String("dir", "/tmp", "directory for output")
+		flagSimulator   = flag.String("simulator", "hosts", fmt.Sprintf("workload simulator to generate series with, one of %v", generator.RegisteredSimulators()))
+		flagSimPlugin   = flag.String("simulator-plugin", "", "path to a Go plugin registering more simulators, needs a cgo build on linux or darwin")
+		flagSimParams   = flag.String("simulator-params", "", "comma separated name=value simulator params, e.g. components=hosts:3,kubernetes:1 for the blend simulator")
+		flagSeed        = flag.Int64("seed", 0, "when non-zero seeds the simulator for reproducible runs")
+		flagMaxSeries   = flag.Int("max-series-created", 0, "when set halts the run once the simulator has created this many series, initially and by churn")
 	)
 
 	flag.Parse()
@@ -45,6 +53,17 @@ func main() {
 		return
 	}
 
+	if *flagSimPlugin != "" {
+		if err := generator.LoadSimulatorPlugin(*flagSimPlugin); err != nil {
+			logger.Fatal("could not load simulator plugin", zap.Error(err))
+		}
+	}
+
+	simParams, err := generator.ParseNameValues(*flagSimParams, "simulator param")
+	if err != nil {
+		logger.Fatal("could not parse simulator params", zap.Error(err))
+	}
+
 	var (
 		cardinality = *flagCardinality
 		dir         = *flagDir
@@ -55,8 +74,17 @@ func main() {
 	start := time.Now().Truncate(blockSize).Add(-1 * blockSize)
 	timeNowFn := func() time.Time { return start }
 
-	gen := generator.NewHostsSimulator(10000, start,
-		generator.HostsSimulatorOptions{TimeNowFn: timeNowFn})
+	gen, err := generator.NewSimulator(*flagSimulator, generator.SimulatorOptions{
+		Targets:          10000,
+		Start:            start,
+		TimeNowFn:        timeNowFn,
+		Seed:             *flagSeed,
+		MaxSeriesCreated: *flagMaxSeries,
+		Params:           simParams,
+	})
+	if err != nil {
+		logger.Fatal("could not create simulator", zap.Error(err))
+	}
 
 	idxOpts := namespace.NewIndexOptions().
 		SetEnabled(true).
@@ -105,22 +133,30 @@ func main() {
 		logger.Fatal("unable to create builder", zap.Error(err))
 	}
 
+	// Pods derive from the seed so seeded runs write the same index
+	seed := time.Now().UnixNano()
+	if *flagSeed != 0 {
+		seed = *flagSeed
+	}
+	rng := rand.New(rand.NewSource(seed))
+
 	var (
 		podTag    = []byte("pod")
 		tagOpts   = models.NewTagOptions().SetIDSchemeType(models.TypeQuoted)
 		tags      = models.NewTags(0, tagOpts)
 		documents int
 	)
-TopLoop:
-	for {
-		ts, err := gen.Generate(10*time.Second, 10*time.Second, 1.0)
-		if err != nil {
-			logger.Fatal("unable to generate series", zap.Error(err))
-		}
-		for _, results := range ts {
-			for _, series := range results {
-				fields := make([]doc.Field, 0, len(series.Labels))
+	errCardinalityReached := errors.New("cardinality reached")
+	for documents < cardinality {
+		before := documents
+		err := gen.GenerateStream(10*time.Second, 10*time.Second, 1.0,
+			func(series prompb.TimeSeries) error {
+				fields := make([]doc.Field, 0, len(series.Labels)+1)
 				for _, label := range series.Labels {
+					if label.Name == string(podTag) {
+						// Replaced below for simulators that emit one
+						continue
+					}
 					fields = append(fields, doc.Field{
 						Name:  []byte(label.Name),
 						Value: []byte(label.Value),
@@ -128,7 +164,7 @@ TopLoop:
 				}
 				fields = append(fields, doc.Field{
 					Name:  podTag,
-					Value: []byte(uuid.NewV4().String()),
+					Value: []byte(newPod(rng)),
 				})
 
 				tags = tags.Reset()
@@ -141,22 +177,29 @@ TopLoop:
 				tags.Normalize()
 
 				id := tags.ID()
-				_, err = builder.Insert(doc.Document{
+				_, err := builder.Insert(doc.Document{
 					ID:     id,
 					Fields: fields,
 				})
 				if err != nil {
-					logger.Fatal("unable to insert document",
-						zap.ByteString("id", id),
-						zap.String("fields", fmt.Sprintf("%+v", fields)),
-						zap.Error(err))
+					return fmt.Errorf("unable to insert document: id=%s, fields=%+v, err=%v",
+						id, fields, err)
 				}
 
 				documents++
 				if documents >= cardinality {
-					break TopLoop
+					return errCardinalityReached
 				}
-			}
+				return nil
+			})
+		if err != nil && err != errCardinalityReached {
+			logger.Fatal("unable to generate series", zap.Error(err))
+		}
+		if documents == before {
+			logger.Fatal("simulator generated no series on a fixed clock",
+				zap.String("simulator", *flagSimulator),
+				zap.Int("documents", documents),
+				zap.Int("cardinality", cardinality))
 		}
 	}
 
@@ -178,3 +221,12 @@ TopLoop:
 		logger.Fatal("unable to close persist", zap.Error(err))
 	}
 }
+
+// newPod returns a version 4 uuid drawn from rng for use as a pod name.
+func newPod(rng *rand.Rand) string {
+	var pod uuid.UUID
+	rng.Read(pod[:])
+	pod.SetVersion(uuid.V4)
+	pod.SetVariant(uuid.VariantRFC4122)
+	return pod.String()
+}
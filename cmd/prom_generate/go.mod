@@ -16,3 +16,6 @@ require (
 	github.com/uber-go/tally v3.3.14+incompatible // indirect
 	go.uber.org/zap v1.13.0
 )
+
+// Build against the pkg module in this repository
+replace github.com/chronosphereiox/high_cardinality_microbenchmark/pkg => ../../pkg
@@ -1,19 +1,37 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	_ "net/http/pprof" // pprof: for debug listen server if configured
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/exposition"
 	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/generator"
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sender"
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sink"
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/transform"
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/upload"
 
+	"github.com/go-kit/kit/log"
 	kitlogzap "github.com/go-kit/kit/log/zap"
 	"github.com/m3db/m3/src/x/instrument"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
 	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
 	uuid "github.com/satori/go.uuid"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -23,63 +41,213 @@ var (
 	blockSize = 2 * time.Hour
 )
 
+const (
+	httpSDPath = "/http_sd"
+
+	duplicateSeriesLogInterval = time.Minute
+	// farmListenerInterval is how often listeners are added for farm
+	// targets added by growth.
+	farmListenerInterval = time.Second
+)
+
 func timeToPromTime(t time.Time) int64 {
 	return t.UnixNano() / int64(time.Millisecond)
 }
 
 func main() {
 	var (
-		flagCardinality = flag.Int("cardinality", 5000000, "cardinality to generate")
-		flagDir         = flag.String("dir", "/tmp", "directory for output")
+		flagCardinality      = flag.Int("cardinality", 5000000, "cardinality to generate")
+		flagDir              = flag.String("dir", "/tmp", "directory for output")
+		flagSimulator        = flag.String("simulator", "hosts", fmt.Sprintf("workload simulator to generate series with, one of %v", generator.RegisteredSimulators()))
+		flagSimPlugin        = flag.String("simulator-plugin", "", "path to a Go plugin registering more simulators, needs a cgo build on linux or darwin")
+		flagSimParams        = flag.String("simulator-params", "", "comma separated name=value simulator params, e.g. components=hosts:3,kubernetes:1 for the blend simulator")
+		flagSeed             = flag.Int64("seed", 0, "when non-zero seeds the simulator for reproducible runs")
+		flagMaxSeries        = flag.Int("max-series-created", 0, "when set halts the run once the simulator has created this many series, initially and by churn")
+		flagLabelBomb        = flag.String("label-bomb", "", "label given a fresh unique value on every scrape of a host, e.g. request_id, by the hosts simulator")
+		flagLabelBombMetrics = flag.String("label-bomb-metrics", "", "comma separated metric names -label-bomb is confined to, all metrics when empty")
+		flagOpenMetrics      = flag.String("openmetrics", "", "write an OpenMetrics backfill file to this path instead of a block")
+		flagUpload           = flag.String("upload", "", "bucket URL to upload the block to, one of file://, s3://, gs:// or azure://")
+		flagPrefix           = flag.String("upload-prefix", "", "object name prefix for the uploaded block, e.g. a tenant ID")
+		flagLabels           = flag.String("external-labels", "", "comma separated name=value external labels for the uploaded block")
+		flagBlocks           = flag.Int("blocks", 1, "number of blocks to write, each with the same series, for compaction workloads")
+		flagDuration         = flag.Duration("block-duration", blockSize, "time range covered by each block")
+		flagOverlap          = flag.Float64("overlap-percent", 0, "fraction [0.0,1.0) of each block's time range overlapping the next block")
+		flagShuffle          = flag.Bool("shuffle-blocks", false, "write blocks out of time order")
+		flagWAL              = flag.Bool("wal", false, "write a head-only WAL under dir/wal instead of blocks, for WAL replay benchmarks")
+		flagWALScrapes       = flag.Int("wal-scrapes", 120, "number of samples per series to write to the WAL")
+		flagWALSegment       = flag.Int("wal-segment-size", wal.DefaultSegmentSize, "WAL segment size in bytes")
+		flagWALCompress      = flag.Bool("wal-compress", false, "snappy compress WAL records")
+		flagListen           = flag.String("listen", "", "address to serve the simulated series at /metrics on instead of writing output")
+		flagTargets          = flag.Int("targets", 10000, "number of simulated targets, e.g. hosts or pods")
+		flagChurn            = flag.Float64("churn", 0, "fraction [0.0,1.0] of series to churn per pass over targets when serving or backfilling")
+		flagFarmTargets      = flag.Int("farm-targets", 0, "when serving, initial number of scrape targets each with its own simulator, served at /targets/<index>/metrics and listed at /http_sd")
+		flagFarmChurn        = flag.Float64("farm-target-churn", 0, "fraction [0.0,1.0] of farm targets replaced by new targets every -farm-target-churn-interval")
+		flagFarmChurnEvery   = flag.Duration("farm-target-churn-interval", time.Minute, "interval between farm target churn")
+		flagFarmGrowth       = flag.String("farm-target-growth", "", "grows the farm targets over the run, <mode>:<factor>:<every>[:<max targets>], e.g. linear:0.5:10m")
+		flagChurnSchedule    = flag.String("churn-schedule", "", "when serving, comma separated <after>:<churn> phases overriding -churn over the run, e.g. 0s:0.01,10m:0.2,12m:0.01")
+		flagMetadata         = flag.Bool("metadata", false, "when serving, write HELP, TYPE and UNIT metadata grouped by metric family")
+		flagFarmListeners    = flag.Bool("farm-listeners", false, "serve each farm target at /metrics on its own port counting up from the listen port")
+		flagBackfill         = flag.Duration("backfill", 0, "replay this much history up to now to -sink as fast as it accepts writes instead of writing output")
+		flagBackfillEvery    = flag.Duration("backfill-interval", 10*time.Second, "simulated scrape interval between backfilled samples")
+		flagSink             = flag.String("sink", "remote_write", fmt.Sprintf("sink to backfill to, one of %v", sink.Registered()))
+		flagSinkEndpoint     = flag.String("sink-endpoint", "", "address or path the sink writes to, e.g. http://localhost:9090/api/v1/write")
+		flagSinkParams       = flag.String("sink-params", "", "comma separated name=value sink params, including relabel_config, sample_keep_percent and aggregate_drop_labels stages applied before writing")
+		flagProbe            = flag.Bool("probe-timestamps", false, "write samples at a range of offsets from now to -sink and log whether each was accepted instead of writing output")
+		flagProbeOffsets     = flag.String("probe-offsets", "", "comma separated offsets from now to probe, e.g. -24h,-1h,0s,10m, defaults to a week in the past to a day in the future")
 	)
 
 	flag.Parse()
 
 	logger := instrument.NewOptions().Logger()
 
-	if *flagCardinality <= 0 || *flagDir == "" {
+	if *flagCardinality <= 0 || *flagDir == "" || *flagBlocks <= 0 ||
+		*flagDuration <= 0 || *flagOverlap < 0 || *flagOverlap >= 1 {
+		flag.Usage()
+		os.Exit(1)
+		return
+	}
+	if *flagWAL && (*flagWALScrapes <= 0 || *flagWALSegment <= 0) {
 		flag.Usage()
 		os.Exit(1)
 		return
 	}
 
+	externalLabels, err := generator.ParseNameValues(*flagLabels, "external label")
+	if err != nil {
+		logger.Fatal("could not parse external labels", zap.Error(err))
+	}
+
+	if *flagSimPlugin != "" {
+		if err := generator.LoadSimulatorPlugin(*flagSimPlugin); err != nil {
+			logger.Fatal("could not load simulator plugin", zap.Error(err))
+		}
+	}
+
+	simParams, err := generator.ParseNameValues(*flagSimParams, "simulator param")
+	if err != nil {
+		logger.Fatal("could not parse simulator params", zap.Error(err))
+	}
+	if *flagLabelBomb != "" {
+		simParams["label_bomb"] = *flagLabelBomb
+		if *flagLabelBombMetrics != "" {
+			simParams["label_bomb_metrics"] = *flagLabelBombMetrics
+		}
+	}
+	simOpts := generator.SimulatorOptions{
+		Targets:          *flagTargets,
+		Seed:             *flagSeed,
+		MaxSeriesCreated: *flagMaxSeries,
+		Params:           simParams,
+	}
+
+	var bucket upload.Bucket
+	if *flagUpload != "" {
+		bucket, err = upload.NewBucketFromURL(*flagUpload)
+		if err != nil {
+			logger.Fatal("could not create upload bucket", zap.Error(err))
+		}
+	}
+
+	if *flagListen != "" {
+		var churnSchedule generator.ChurnSchedule
+		if *flagChurnSchedule != "" {
+			var err error
+			churnSchedule, err = generator.ParseChurnSchedule(*flagChurnSchedule)
+			if err != nil {
+				logger.Fatal("invalid churn schedule", zap.Error(err))
+			}
+		}
+		farmOpts := exposition.FarmOptions{
+			Simulator:        *flagSimulator,
+			Targets:          *flagFarmTargets,
+			TargetChurn:      *flagFarmChurn,
+			TargetChurnEvery: *flagFarmChurnEvery,
+		}
+		if *flagFarmGrowth != "" {
+			var err error
+			farmOpts.TargetGrowth, err = generator.ParseGrowth(*flagFarmGrowth)
+			if err != nil {
+				logger.Fatal("invalid farm target growth", zap.Error(err))
+			}
+		}
+		serve(logger, *flagListen, *flagSimulator, simOpts, *flagChurn,
+			churnSchedule, *flagMetadata, farmOpts, *flagFarmListeners)
+		return
+	}
+
+	if *flagProbe {
+		sinkParams, err := generator.ParseNameValues(*flagSinkParams, "sink param")
+		if err != nil {
+			logger.Fatal("could not parse sink params", zap.Error(err))
+		}
+		offsets, err := parseDurations(*flagProbeOffsets)
+		if err != nil {
+			logger.Fatal("could not parse probe offsets", zap.Error(err))
+		}
+		probeTimestamps(logger, offsets, *flagSink, sink.Options{
+			Endpoint: *flagSinkEndpoint,
+			Params:   sinkParams,
+		})
+		return
+	}
+
+	if *flagBackfill > 0 {
+		sinkParams, err := generator.ParseNameValues(*flagSinkParams, "sink param")
+		if err != nil {
+			logger.Fatal("could not parse sink params", zap.Error(err))
+		}
+		backfill(logger, *flagSimulator, simOpts, *flagChurn, *flagBackfill,
+			*flagBackfillEvery, *flagSink, sink.Options{
+				Endpoint: *flagSinkEndpoint,
+				Params:   sinkParams,
+			})
+		return
+	}
+
 	var (
-		cardinality = *flagCardinality
-		dir         = *flagDir
-		samples     []*tsdb.MetricSample
+		cardinality   = *flagCardinality
+		dir           = *flagDir
+		blockDuration = *flagDuration
+		samples       []*tsdb.MetricSample
 	)
 	srv := httptest.NewServer(http.DefaultServeMux)
 	logger.Info("test server with pprof", zap.String("url", srv.URL))
 
-	start := time.Now().Truncate(blockSize).Add(-1 * blockSize)
+	start := time.Now().Truncate(blockDuration).Add(-1 * blockDuration)
 	timeNowFn := func() time.Time { return start }
 
-	gen := generator.NewHostsSimulator(10000, start,
-		generator.HostsSimulatorOptions{TimeNowFn: timeNowFn})
+	simOpts.Start = start
+	simOpts.TimeNowFn = timeNowFn
+	gen, err := generator.NewSimulator(*flagSimulator, simOpts)
+	if err != nil {
+		logger.Fatal("could not create simulator", zap.Error(err))
+	}
 
-TopLoop:
-	for {
-		ts, err := gen.Generate(10*time.Second, 10*time.Second, 1.0)
-		if err != nil {
-			logger.Fatal("unable to generate series", zap.Error(err))
-		}
-		for _, results := range ts {
-			for _, series := range results {
-				sampleLabels := make([]labels.Label, 0, len(series.Labels))
+	// Pods and the block order derive from the seed so seeded runs write
+	// the same blocks
+	seed := time.Now().UnixNano()
+	if *flagSeed != 0 {
+		seed = *flagSeed
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	errCardinalityReached := errors.New("cardinality reached")
+	for len(samples) < cardinality {
+		before := len(samples)
+		err := gen.GenerateStream(10*time.Second, 10*time.Second, 1.0,
+			func(series prompb.TimeSeries) error {
+				builder := labels.NewBuilder(nil)
 				for _, label := range series.Labels {
-					sampleLabels = append(sampleLabels, labels.Label{
-						Name:  label.Name,
-						Value: label.Value,
-					})
+					builder.Set(label.Name, label.Value)
 				}
-				sampleLabels = append(sampleLabels, labels.Label{
-					Name:  "pod",
-					Value: uuid.NewV4().String(),
-				})
+				// Replaces the pod label of simulators that emit one
+				builder.Set("pod", newPod(rng))
+				sampleLabels := builder.Labels()
 
 				if len(series.Samples) != 1 {
-					logger.Fatal("expected single sample",
-						zap.Int("samples", len(series.Samples)))
+					return fmt.Errorf("block, WAL and OpenMetrics output need one sample per series "+
+						"per pass, use -listen or -backfill for this simulator: simulator=%s, samples=%d",
+						*flagSimulator, len(series.Samples))
 				}
 				for _, value := range series.Samples {
 					sample := &tsdb.MetricSample{
@@ -89,10 +257,20 @@ TopLoop:
 					}
 					samples = append(samples, sample)
 					if len(samples) >= cardinality {
-						break TopLoop
+						return errCardinalityReached
 					}
 				}
-			}
+				return nil
+			})
+		if err != nil && err != errCardinalityReached {
+			logger.Fatal("unable to generate series", zap.Error(err))
+		}
+		if len(samples) == before {
+			logger.Fatal("simulator generated no series on a fixed clock, "+
+				"use -listen or -backfill for this simulator",
+				zap.String("simulator", *flagSimulator),
+				zap.Int("samples", len(samples)),
+				zap.Int("cardinality", cardinality))
 		}
 	}
 
@@ -105,7 +283,7 @@ TopLoop:
 		}
 	}
 
-	hardEnd := start.Add(blockSize)
+	hardEnd := start.Add(blockDuration)
 	if end.After(hardEnd) {
 		logger.Fatal("too many samples for block",
 			zap.Stringer("start", start),
@@ -116,14 +294,523 @@ TopLoop:
 	// To keep chunk range the normal prometheus amount
 	end = hardEnd
 
-	logger.Info("writing block", zap.Int("samples", len(samples)))
+	if path := *flagOpenMetrics; path != "" {
+		logger.Info("writing openmetrics", zap.Int("samples", len(samples)))
+		if err := writeOpenMetrics(path, samples); err != nil {
+			logger.Fatal("could not write openmetrics", zap.Error(err))
+		}
+		logger.Info("created openmetrics", zap.String("path", path))
+		return
+	}
 
 	kitLogger := kitlogzap.NewZapSugarLogger(logger, zapcore.InfoLevel)
-	name, err := tsdb.CreateBlock(samples, dir,
-		timeToPromTime(start), timeToPromTime(end), kitLogger)
+	if *flagWAL {
+		walDir := filepath.Join(dir, "wal")
+		logger.Info("writing wal",
+			zap.Int("series", len(samples)),
+			zap.Int("scrapes", *flagWALScrapes))
+		err := writeWAL(walDir, samples, *flagWALScrapes, *flagWALSegment,
+			*flagWALCompress, kitLogger)
+		if err != nil {
+			logger.Fatal("could not write wal", zap.Error(err))
+		}
+		logger.Info("created wal", zap.String("path", walDir))
+		return
+	}
+
+	// Lay out blocks backwards in time from the generated block, each
+	// overlapping the next by the configured amount
+	step := time.Duration(float64(blockDuration) * (1 - *flagOverlap))
+	order := make([]int, *flagBlocks)
+	for i := range order {
+		order[i] = i
+	}
+	if *flagShuffle {
+		rng.Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+	}
+
+	for _, i := range order {
+		offset := -1 * time.Duration(*flagBlocks-1-i) * step
+		blockSamples := shiftSamples(samples, offset)
+
+		logger.Info("writing block",
+			zap.Int("samples", len(blockSamples)),
+			zap.Stringer("start", start.Add(offset)),
+			zap.Stringer("end", end.Add(offset)))
+
+		name, err := tsdb.CreateBlock(blockSamples, dir,
+			timeToPromTime(start.Add(offset)), timeToPromTime(end.Add(offset)),
+			kitLogger)
+		if err != nil {
+			logger.Fatal("could not create block", zap.Error(err))
+		}
+
+		logger.Info("created block", zap.String("name", name))
+
+		if bucket == nil {
+			continue
+		}
+
+		stats, err := upload.UploadBlock(context.Background(), bucket,
+			name, upload.BlockOptions{
+				Prefix:         *flagPrefix,
+				ExternalLabels: externalLabels,
+			})
+		if err != nil {
+			logger.Fatal("could not upload block", zap.Error(err))
+		}
+
+		logger.Info("uploaded block",
+			zap.String("bucket", bucket.Name()),
+			zap.Int("files", stats.Files),
+			zap.Int64("bytes", stats.Bytes),
+			zap.Float64("bytesPerSecond", stats.BytesPerSecond()),
+			zap.Stringer("took", stats.Duration))
+	}
+}
+
+func serve(
+	logger *zap.Logger,
+	addr string,
+	simulator string,
+	simOpts generator.SimulatorOptions,
+	churn float64,
+	churnSchedule generator.ChurnSchedule,
+	metadata bool,
+	farmOpts exposition.FarmOptions,
+	farmListeners bool,
+) {
+	simOpts.Start = time.Now()
+	handlerOpts := exposition.HandlerOptions{
+		NewSeriesPercent: churn,
+		ChurnSchedule:    churnSchedule,
+		Metadata:         metadata,
+	}
+
+	mux := http.NewServeMux()
+	if farmOpts.Targets <= 0 {
+		gen, err := generator.NewSimulator(simulator, simOpts)
+		if err != nil {
+			logger.Fatal("could not create simulator", zap.Error(err))
+		}
+		mux.Handle("/metrics", exposition.NewHandler(gen, handlerOpts))
+		logger.Info("serving metrics",
+			zap.String("addr", addr),
+			zap.Int("activeSeries", gen.ActiveSeries()))
+		if sim, ok := gen.(generator.DuplicateSeriesSimulator); ok {
+			go logDuplicateSeriesEvery(logger, sim.DuplicateSeries, duplicateSeriesLogInterval)
+		}
+	} else {
+		farmOpts.SimulatorOptions = simOpts
+		farmOpts.Handler = handlerOpts
+		farm, err := exposition.NewFarm(farmOpts)
+		if err != nil {
+			logger.Fatal("could not create target farm", zap.Error(err))
+		}
+		logger.Info("serving target farm",
+			zap.String("addr", addr),
+			zap.Int("targets", farm.Targets()),
+			zap.Bool("listenerPerTarget", farmListeners),
+			zap.Int("activeSeries", farm.ActiveSeries()))
+		go logDuplicateSeriesEvery(logger, farm.DuplicateSeries, duplicateSeriesLogInterval)
+		if farmListeners {
+			serveFarmListeners(logger, addr, farm)
+			return
+		}
+		mux.Handle("/targets/", farm)
+		mux.Handle(httpSDPath, farm.ServiceDiscovery(nil))
+	}
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Fatal("could not serve metrics", zap.Error(err))
+	}
+}
+
+// backfill replays history up to now from a simulator on a virtual clock to
+// the sink, as fast as it accepts writes.
+func backfill(
+	logger *zap.Logger,
+	simulator string,
+	simOpts generator.SimulatorOptions,
+	churn float64,
+	history time.Duration,
+	interval time.Duration,
+	sinkName string,
+	sinkOpts sink.Options,
+) {
+	end := time.Now().Truncate(interval)
+	start := end.Add(-1 * history)
+	clock := generator.NewVirtualClock(start)
+	simOpts.Start = start
+	simOpts.TimeNowFn = clock.Now
+	gen, err := generator.NewSimulator(simulator, simOpts)
 	if err != nil {
-		logger.Fatal("could not create block", zap.Error(err))
+		logger.Fatal("could not create simulator", zap.Error(err))
+	}
+	if sources, ok := gen.(interface {
+		Source(series prompb.TimeSeries) string
+	}); ok {
+		sinkOpts.SourceFn = sources.Source
+	}
+	sinkOpts.OnBreakerTrip = func(reason string) {
+		logger.Warn("sink circuit breaker tripped", zap.String("reason", reason))
+	}
+	out, err := sink.New(sinkName, sinkOpts)
+	if err != nil {
+		logger.Fatal("could not create sink", zap.Error(err))
+	}
+	defer out.Close()
+	stageOpts, err := transform.ParseSinkOptions(sinkOpts.Params)
+	if err != nil {
+		logger.Fatal("could not create sink stages", zap.Error(err))
+	}
+	var stages *transform.Sink
+	write := out
+	if stageOpts.Enabled() {
+		stages = transform.NewSink(out, stageOpts)
+		write = stages
+	}
+
+	logger.Info("backfilling",
+		zap.String("sink", sinkName),
+		zap.Stringer("start", start),
+		zap.Stringer("end", end),
+		zap.Stringer("interval", interval),
+		zap.Int("activeSeries", gen.ActiveSeries()))
+	stats, err := sink.Backfill(context.Background(), gen, clock, write, sink.BackfillOptions{
+		Start:            start,
+		End:              end,
+		ScrapeInterval:   interval,
+		NewSeriesPercent: churn,
+	})
+	if err != nil {
+		logger.Fatal("could not backfill", zap.Error(err))
+	}
+	logger.Info("backfilled",
+		zap.Int64("scrapes", stats.Scrapes),
+		zap.Int64("series", stats.Series),
+		zap.Int64("samples", stats.Samples),
+		zap.Float64("samplesPerSecond", float64(stats.Samples)/stats.Took.Seconds()),
+		zap.Stringer("took", stats.Took),
+		zap.Int("activeSeries", gen.ActiveSeries()))
+	if stages != nil {
+		logStageStats(logger, stages.Stats())
+	}
+	if statsSink, ok := out.(sink.StatsSink); ok {
+		logSourceStats(logger, statsSink.SourceStats(), stats.Took)
+	}
+	if s, ok := out.(*sender.Sender); ok {
+		if senderStats := s.Stats(); senderStats.Failures > 0 {
+			logger.Warn("backfill requests failed",
+				zap.Int64("requests", senderStats.Requests),
+				zap.Int64("failures", senderStats.Failures),
+				zap.Int64("breakerTrips", senderStats.BreakerTrips))
+		}
+	}
+	if sim, ok := gen.(generator.DuplicateSeriesSimulator); ok {
+		if duplicates := sim.DuplicateSeries(); duplicates.Series > 0 {
+			logDuplicateSeries(logger, duplicates)
+		}
+	}
+}
+
+func logStageStats(logger *zap.Logger, stats transform.SinkStats) {
+	if stats.Relabel != nil {
+		logger.Info("relabeled",
+			zap.Int64("seriesIn", stats.Relabel.SeriesIn),
+			zap.Int64("seriesDropped", stats.Relabel.SeriesDropped),
+			zap.Int64("samplesIn", stats.Relabel.SamplesIn),
+			zap.Int64("samplesDropped", stats.Relabel.SamplesDropped))
+	}
+	if stats.Sample != nil {
+		fields := []zap.Field{
+			zap.Int("seriesSeen", stats.Sample.SeriesSeen),
+			zap.Int("seriesKept", stats.Sample.SeriesKept),
+			zap.Int64("samplesSeen", stats.Sample.SamplesSeen),
+			zap.Int64("samplesKept", stats.Sample.SamplesKept),
+			zap.Float64("estimatedSeries", stats.Sample.EstimatedSeries),
+		}
+		names := make([]string, 0, len(stats.Sample.LabelValues))
+		for name := range stats.Sample.LabelValues {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			counts := stats.Sample.LabelValues[name]
+			fields = append(fields,
+				zap.Int("actualValues."+name, counts.Actual),
+				zap.Int("sampledValues."+name, counts.Sampled))
+		}
+		logger.Info("sampled", fields...)
+	}
+	if stats.Aggregate != nil {
+		logger.Info("aggregated",
+			zap.Int("seriesIn", stats.Aggregate.SeriesIn),
+			zap.Int("seriesOut", stats.Aggregate.SeriesOut),
+			zap.Int64("samplesIn", stats.Aggregate.SamplesIn),
+			zap.Int64("samplesOut", stats.Aggregate.SamplesOut))
+	}
+}
+
+// logDuplicateSeriesEvery logs the duplicate series detected while serving
+// whenever more were detected since the last interval.
+func logDuplicateSeriesEvery(
+	logger *zap.Logger,
+	duplicatesFn func() generator.DuplicateSeriesStats,
+	interval time.Duration,
+) {
+	var logged int64
+	for range time.Tick(interval) {
+		if duplicates := duplicatesFn(); duplicates.Series > logged {
+			logDuplicateSeries(logger, duplicates)
+			logged = duplicates.Series
+		}
+	}
+}
+
+func logDuplicateSeries(logger *zap.Logger, duplicates generator.DuplicateSeriesStats) {
+	logger.Warn("series duplicated across hosts",
+		zap.Int64("duplicateSeries", duplicates.Series),
+		zap.Int64("activeDuplicateSeries", duplicates.Active),
+		zap.Strings("examples", duplicates.Examples))
+}
+
+// probeTimestamps probes which offsets from now the named sink accepts
+// samples at and logs the outcome per offset.
+func probeTimestamps(
+	logger *zap.Logger,
+	offsets []time.Duration,
+	sinkName string,
+	sinkOpts sink.Options,
+) {
+	out, err := sink.New(sinkName, sinkOpts)
+	if err != nil {
+		logger.Fatal("could not create sink", zap.Error(err))
+	}
+	defer out.Close()
+
+	results, err := sink.ProbeTimestamps(context.Background(), out, sink.TimestampProbeOptions{
+		Offsets: offsets,
+	})
+	if err != nil {
+		logger.Fatal("could not probe timestamps", zap.Error(err))
+	}
+	for _, result := range results {
+		logger.Info("probed timestamp",
+			zap.String("sink", sinkName),
+			zap.Stringer("offset", result.Offset),
+			zap.Bool("accepted", result.Accepted),
+			zap.NamedError("err", result.Err))
+	}
+}
+
+// logSourceStats logs what a sink wrote per source, sorted by source.
+func logSourceStats(logger *zap.Logger, sources map[string]sink.SourceStats, took time.Duration) {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source := sources[name]
+		logger.Info("backfilled source",
+			zap.String("source", name),
+			zap.Int64("series", source.Series),
+			zap.Int64("samples", source.Samples),
+			zap.Int64("failedSeries", source.FailedSeries),
+			zap.Int64("failedSamples", source.FailedSamples),
+			zap.Int64("failedRequests", source.FailedRequests),
+			zap.Float64("samplesPerSecond", source.SamplesPerSecond(took)))
+	}
+}
+
+// serveFarmListeners serves each farm target at /metrics on its own port,
+// counting up from the port of addr by listener slot, with service discovery
+// on every port. Listeners are added as target growth adds slots.
+func serveFarmListeners(logger *zap.Logger, addr string, farm *exposition.Farm) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		logger.Fatal("invalid listen address", zap.String("addr", addr), zap.Error(err))
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		logger.Fatal("invalid listen port", zap.String("addr", addr), zap.Error(err))
+	}
+
+	targetAddr := func(slot int) string {
+		return net.JoinHostPort(host, strconv.Itoa(port+slot))
+	}
+	sd := farm.ServiceDiscovery(targetAddr)
+
+	errCh := make(chan error, 1)
+	listening := 0
+	listen := func() {
+		for ; listening < farm.Targets(); listening++ {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", farm.Handler(listening))
+			mux.Handle(httpSDPath, sd)
+			listenAddr := targetAddr(listening)
+			go func() {
+				err := http.ListenAndServe(listenAddr, mux)
+				select {
+				case errCh <- err:
+				default:
+				}
+			}()
+		}
+	}
+	listen()
+
+	ticker := time.NewTicker(farmListenerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errCh:
+			logger.Fatal("could not serve metrics", zap.Error(err))
+		case <-ticker.C:
+			before := listening
+			listen()
+			if listening > before {
+				logger.Info("serving grown farm targets", zap.Int("targets", listening))
+			}
+		}
+	}
+}
+
+// shiftSamples returns the samples moved in time by the offset.
+// newPod returns a version 4 uuid drawn from rng for use as a pod name.
+func newPod(rng *rand.Rand) string {
+	var pod uuid.UUID
+	rng.Read(pod[:])
+	pod.SetVersion(4)
+	pod.SetVariant()
+	return pod.String()
+}
+
+func shiftSamples(samples []*tsdb.MetricSample, offset time.Duration) []*tsdb.MetricSample {
+	if offset == 0 {
+		return samples
+	}
+	offsetMs := int64(offset / time.Millisecond)
+	shifted := make([]*tsdb.MetricSample, 0, len(samples))
+	for _, sample := range samples {
+		shifted = append(shifted, &tsdb.MetricSample{
+			TimestampMs: sample.TimestampMs + offsetMs,
+			Value:       sample.Value,
+			Labels:      sample.Labels,
+		})
+	}
+	return shifted
+}
+
+// parseDurations parses a comma separated list of durations, which may be
+// negative.
+func parseDurations(value string) ([]time.Duration, error) {
+	var result []time.Duration
+	for _, str := range strings.Split(value, ",") {
+		if str = strings.TrimSpace(str); str == "" {
+			continue
+		}
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: value=%s, err=%v", str, err)
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+func writeOpenMetrics(path string, samples []*tsdb.MetricSample) error {
+	out, err := sink.NewOpenMetricsFileSink(path)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]prompb.TimeSeries, 0, len(samples))
+	for _, sample := range samples {
+		seriesLabels := make([]prompb.Label, 0, len(sample.Labels))
+		for _, l := range sample.Labels {
+			seriesLabels = append(seriesLabels, prompb.Label{
+				Name:  l.Name,
+				Value: l.Value,
+			})
+		}
+		batch = append(batch, prompb.TimeSeries{
+			Labels: seriesLabels,
+			Samples: []prompb.Sample{{
+				Value:     sample.Value,
+				Timestamp: sample.TimestampMs,
+			}},
+		})
+	}
+
+	if err := out.Write(context.Background(), batch); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+const (
+	walRecordSize     = 10000
+	walScrapeInterval = 10 * time.Second
+)
+
+func writeWAL(
+	dir string,
+	samples []*tsdb.MetricSample,
+	scrapes int,
+	segmentSize int,
+	compress bool,
+	logger log.Logger,
+) error {
+	w, err := wal.NewSize(logger, nil, dir, segmentSize, compress)
+	if err != nil {
+		return err
+	}
+
+	var (
+		enc        record.Encoder
+		buf        []byte
+		refSeries  = make([]record.RefSeries, 0, walRecordSize)
+		refSamples = make([]record.RefSample, 0, walRecordSize)
+	)
+	for i := 0; i < len(samples); i += walRecordSize {
+		refSeries = refSeries[:0]
+		for j := i; j < len(samples) && j < i+walRecordSize; j++ {
+			refSeries = append(refSeries, record.RefSeries{
+				Ref:    uint64(j + 1),
+				Labels: labels.New(samples[j].Labels...),
+			})
+		}
+		buf = enc.Series(refSeries, buf[:0])
+		if err := w.Log(buf); err != nil {
+			w.Close()
+			return fmt.Errorf("could not log series: err=%v", err)
+		}
+	}
+
+	intervalMs := int64(walScrapeInterval / time.Millisecond)
+	for scrape := 0; scrape < scrapes; scrape++ {
+		for i := 0; i < len(samples); i += walRecordSize {
+			refSamples = refSamples[:0]
+			for j := i; j < len(samples) && j < i+walRecordSize; j++ {
+				refSamples = append(refSamples, record.RefSample{
+					Ref: uint64(j + 1),
+					T:   samples[j].TimestampMs + int64(scrape)*intervalMs,
+					V:   samples[j].Value,
+				})
+			}
+			buf = enc.Samples(refSamples, buf[:0])
+			if err := w.Log(buf); err != nil {
+				w.Close()
+				return fmt.Errorf("could not log samples: scrape=%d, err=%v",
+					scrape, err)
+			}
+		}
 	}
 
-	logger.Info("created block", zap.String("name", name))
+	return w.Close()
 }
@@ -0,0 +1,312 @@
+package exposition
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/generator"
+)
+
+const (
+	farmPathPrefix = "/targets/"
+
+	// FarmTargetLabel is the label service discovery attaches to each farm
+	// target with its index.
+	FarmTargetLabel = "farm_target"
+)
+
+type FarmOptions struct {
+	// Simulator is the registered name of the simulator backing each
+	// target.
+	Simulator string
+	// Targets is the initial number of scrape targets.
+	Targets int
+	// SimulatorOptions are used to create each target's simulator, with
+	// Seed incremented per target index when set so targets differ. Its
+	// TimeNowFn, defaulting to time.Now, also drives target churn and
+	// growth.
+	SimulatorOptions generator.SimulatorOptions
+	Handler          HandlerOptions
+	// TargetChurn is the fraction [0.0,1.0] of targets retired and
+	// replaced by new ones every TargetChurnEvery, oldest first, as when
+	// pods are rescheduled. New targets get a new index and simulator and
+	// take over the retired targets' listener slots.
+	TargetChurn      float64
+	TargetChurnEvery time.Duration
+	// TargetGrowth grows the number of targets over the run, its MaxHosts
+	// capping the targets.
+	TargetGrowth generator.Growth
+}
+
+type farmTarget struct {
+	index   int
+	slot    int
+	handler *Handler
+}
+
+// Farm is a set of scrape targets each backed by its own simulator, served
+// either on one listener at /targets/<index>/metrics or on a listener per
+// slot using Handler. Targets are added and retired by TargetChurn and
+// TargetGrowth as of each request to the farm, and service discovery lists
+// the targets current at the time, so scrapers follow the churn. Requests
+// for retired targets are not found.
+type Farm struct {
+	sync.Mutex
+	opts       FarmOptions
+	timeNowFn  func() time.Time
+	start      time.Time
+	targets    []*farmTarget
+	byIndex    map[int]*farmTarget
+	bySlot     map[int]*farmTarget
+	nextIndex  int
+	churned    int
+	churnCarry float64
+	retired    generator.DuplicateSeriesStats
+}
+
+var _ http.Handler = (*Farm)(nil)
+
+func NewFarm(opts FarmOptions) (*Farm, error) {
+	if opts.Targets <= 0 {
+		return nil, fmt.Errorf("farm needs at least one target: targets=%d",
+			opts.Targets)
+	}
+	if !(opts.TargetChurn >= 0 && opts.TargetChurn <= 1) {
+		return nil, fmt.Errorf("target churn not between [0.0,1.0]: value=%v",
+			opts.TargetChurn)
+	}
+	if opts.TargetChurn > 0 && opts.TargetChurnEvery <= 0 {
+		return nil, fmt.Errorf("target churn interval must be positive: every=%v",
+			opts.TargetChurnEvery)
+	}
+	if err := opts.TargetGrowth.Validate(); err != nil {
+		return nil, err
+	}
+
+	timeNowFn := time.Now
+	if opts.SimulatorOptions.TimeNowFn != nil {
+		timeNowFn = opts.SimulatorOptions.TimeNowFn
+	}
+	f := &Farm{
+		opts:      opts,
+		timeNowFn: timeNowFn,
+		start:     timeNowFn(),
+		byIndex:   make(map[int]*farmTarget),
+		bySlot:    make(map[int]*farmTarget),
+	}
+	for i := 0; i < opts.Targets; i++ {
+		if err := f.addTargetWithLock(i, opts.SimulatorOptions.Start); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// addTargetWithLock creates a target with the next index in the given
+// listener slot.
+func (f *Farm) addTargetWithLock(slot int, start time.Time) error {
+	index := f.nextIndex
+	simOpts := f.opts.SimulatorOptions
+	simOpts.Start = start
+	if simOpts.Seed != 0 {
+		simOpts.Seed += int64(index)
+	}
+	sim, err := generator.NewSimulator(f.opts.Simulator, simOpts)
+	if err != nil {
+		return err
+	}
+	f.nextIndex++
+
+	target := &farmTarget{
+		index:   index,
+		slot:    slot,
+		handler: NewHandler(sim, f.opts.Handler),
+	}
+	f.targets = append(f.targets, target)
+	f.byIndex[index] = target
+	f.bySlot[slot] = target
+	return nil
+}
+
+// updateWithLock adds the targets due by growth and replaces those due by
+// churn since the last update.
+func (f *Farm) updateWithLock() error {
+	now := f.timeNowFn()
+	elapsed := now.Sub(f.start)
+
+	for want := f.opts.TargetGrowth.At(f.opts.Targets, elapsed); len(f.targets) < want; {
+		if err := f.addTargetWithLock(len(f.targets), now); err != nil {
+			return err
+		}
+	}
+
+	if f.opts.TargetChurn <= 0 {
+		return nil
+	}
+	for rounds := int(elapsed / f.opts.TargetChurnEvery); f.churned < rounds; f.churned++ {
+		f.churnCarry += f.opts.TargetChurn * float64(len(f.targets))
+		replace := int(math.Min(f.churnCarry, float64(len(f.targets))))
+		f.churnCarry -= float64(replace)
+
+		retired := f.targets[:replace]
+		f.targets = append([]*farmTarget(nil), f.targets[replace:]...)
+		for _, target := range retired {
+			delete(f.byIndex, target.index)
+			if sim, ok := target.handler.sim.(generator.DuplicateSeriesSimulator); ok {
+				stats := sim.DuplicateSeries()
+				stats.Active = 0
+				f.retired.Add(stats)
+			}
+			if err := f.addTargetWithLock(target.slot, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// currentTargets returns the targets after applying churn and growth.
+func (f *Farm) currentTargets() ([]*farmTarget, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	err := f.updateWithLock()
+	return f.targets, err
+}
+
+// Targets returns the current number of targets, which is also the number
+// of listener slots.
+func (f *Farm) Targets() int {
+	targets, _ := f.currentTargets()
+	return len(targets)
+}
+
+// Handler returns the handler serving whichever target currently occupies
+// the given listener slot, so that a listener per slot serves the targets
+// that replace retired ones.
+func (f *Farm) Handler(slot int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		err := f.updateWithLock()
+		target, ok := f.bySlot[slot]
+		f.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		target.handler.ServeHTTP(w, r)
+	})
+}
+
+// Path returns the path the target with the given index is served at by
+// the farm's own ServeHTTP.
+func (f *Farm) Path(index int) string {
+	return farmPathPrefix + strconv.Itoa(index) + "/metrics"
+}
+
+// ActiveSeries returns the number of series currently emitted by all
+// targets.
+func (f *Farm) ActiveSeries() int {
+	targets, _ := f.currentTargets()
+	active := 0
+	for _, target := range targets {
+		active += target.handler.sim.ActiveSeries()
+	}
+	return active
+}
+
+// DuplicateSeries returns the duplicate series detected by all targets,
+// including retired ones, each target detecting duplicates only among its
+// own series.
+func (f *Farm) DuplicateSeries() generator.DuplicateSeriesStats {
+	targets, _ := f.currentTargets()
+	f.Lock()
+	var stats generator.DuplicateSeriesStats
+	stats.Add(f.retired)
+	f.Unlock()
+	for _, target := range targets {
+		if sim, ok := target.handler.sim.(generator.DuplicateSeriesSimulator); ok {
+			stats.Add(sim.DuplicateSeries())
+		}
+	}
+	return stats
+}
+
+func (f *Farm) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, farmPathPrefix)
+	if rest == r.URL.Path || !strings.HasSuffix(rest, "/metrics") {
+		http.NotFound(w, r)
+		return
+	}
+	index, err := strconv.Atoi(strings.TrimSuffix(rest, "/metrics"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.Lock()
+	err = f.updateWithLock()
+	target, ok := f.byIndex[index]
+	f.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	target.handler.ServeHTTP(w, r)
+}
+
+type httpSDTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// ServiceDiscovery returns a handler listing the farm's current targets in
+// the Prometheus http_sd format, each labeled with its index. With a nil
+// addr every target is listed at the requested host under its farm path,
+// otherwise each is listed at addr(slot) under /metrics as when serving a
+// listener per slot.
+func (f *Farm) ServiceDiscovery(addr func(slot int) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets, err := f.currentTargets()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		groups := make([]httpSDTargetGroup, 0, len(targets))
+		for _, target := range targets {
+			group := httpSDTargetGroup{
+				Labels: map[string]string{
+					FarmTargetLabel: strconv.Itoa(target.index),
+					"simulator":     f.opts.Simulator,
+				},
+			}
+			if addr == nil {
+				group.Targets = []string{r.Host}
+				group.Labels["__metrics_path__"] = f.Path(target.index)
+			} else {
+				group.Targets = []string{addr(target.slot)}
+			}
+			groups = append(groups, group)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(groups); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,233 @@
+package exposition
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/generator"
+)
+
+func newTestFarm(t *testing.T, targets int) *Farm {
+	t.Helper()
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	f, err := NewFarm(FarmOptions{
+		Simulator: "hosts",
+		Targets:   targets,
+		SimulatorOptions: generator.SimulatorOptions{
+			Targets:   1,
+			Start:     start,
+			TimeNowFn: func() time.Time { return start },
+			Seed:      1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFarmServesTargets(t *testing.T) {
+	f := newTestFarm(t, 3)
+	if f.Targets() != 3 {
+		t.Fatalf("unexpected targets: targets=%d", f.Targets())
+	}
+
+	lines := 0
+	for i := 0; i < f.Targets(); i++ {
+		req := httptest.NewRequest(http.MethodGet, f.Path(i), nil)
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status: path=%s, code=%d", f.Path(i), rec.Code)
+		}
+		lines += strings.Count(rec.Body.String(), "\n")
+	}
+	if active := f.ActiveSeries(); lines != active {
+		t.Errorf("scraped series do not match the active series: scraped=%d, active=%d",
+			lines, active)
+	}
+
+	for _, path := range []string{"/targets/3/metrics", "/targets/-1/metrics",
+		"/targets/x/metrics", "/targets/0", "/metrics"} {
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("expected not found: path=%s, code=%d", path, rec.Code)
+		}
+	}
+}
+
+func TestFarmRejectsNoTargets(t *testing.T) {
+	if _, err := NewFarm(FarmOptions{Simulator: "hosts"}); err == nil {
+		t.Error("expected an error for a farm without targets")
+	}
+}
+
+func decodeTargetGroups(t *testing.T, h http.Handler) []httpSDTargetGroup {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "http://farm:9090/sd", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type: value=%s", ct)
+	}
+	var groups []httpSDTargetGroup
+	if err := json.Unmarshal(rec.Body.Bytes(), &groups); err != nil {
+		t.Fatal(err)
+	}
+	return groups
+}
+
+func TestFarmServiceDiscovery(t *testing.T) {
+	f := newTestFarm(t, 2)
+
+	groups := decodeTargetGroups(t, f.ServiceDiscovery(nil))
+	if len(groups) != 2 {
+		t.Fatalf("unexpected target groups: groups=%v", groups)
+	}
+	for i, group := range groups {
+		if len(group.Targets) != 1 || group.Targets[0] != "farm:9090" ||
+			group.Labels["__metrics_path__"] != f.Path(i) ||
+			group.Labels[FarmTargetLabel] != strconv.Itoa(i) ||
+			group.Labels["simulator"] != "hosts" {
+			t.Errorf("unexpected target group: index=%d, group=%+v", i, group)
+		}
+	}
+
+	groups = decodeTargetGroups(t, f.ServiceDiscovery(func(slot int) string {
+		return "localhost:" + strconv.Itoa(9100+slot)
+	}))
+	for i, group := range groups {
+		_, hasPath := group.Labels["__metrics_path__"]
+		if len(group.Targets) != 1 || group.Targets[0] != "localhost:"+strconv.Itoa(9100+i) || hasPath {
+			t.Errorf("unexpected target group of a listener per target: index=%d, group=%+v", i, group)
+		}
+	}
+}
+
+func targetIndexes(t *testing.T, f *Farm) map[string]string {
+	t.Helper()
+
+	indexes := make(map[string]string)
+	for _, group := range decodeTargetGroups(t, f.ServiceDiscovery(func(slot int) string {
+		return strconv.Itoa(slot)
+	})) {
+		indexes[group.Labels[FarmTargetLabel]] = group.Targets[0]
+	}
+	return indexes
+}
+
+func TestFarmTargetChurn(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := generator.NewVirtualClock(start)
+	f, err := NewFarm(FarmOptions{
+		Simulator: "hosts",
+		Targets:   4,
+		SimulatorOptions: generator.SimulatorOptions{
+			Targets:   1,
+			Start:     start,
+			TimeNowFn: clock.Now,
+			Seed:      1,
+		},
+		TargetChurn:      0.5,
+		TargetChurnEvery: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Set(start.Add(time.Minute))
+	indexes := targetIndexes(t, f)
+	// The two oldest targets are replaced by new ones in their slots
+	expected := map[string]string{"2": "2", "3": "3", "4": "0", "5": "1"}
+	if len(indexes) != len(expected) {
+		t.Fatalf("unexpected targets after churn: targets=%v", indexes)
+	}
+	for index, slot := range expected {
+		if indexes[index] != slot {
+			t.Errorf("unexpected target after churn: index=%s, slot=%s, targets=%v",
+				index, slot, indexes)
+		}
+	}
+
+	for path, code := range map[string]int{
+		f.Path(0): http.StatusNotFound,
+		f.Path(1): http.StatusNotFound,
+		f.Path(4): http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != code {
+			t.Errorf("unexpected status: path=%s, code=%d, expected=%d", path, rec.Code, code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	f.Handler(0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("slot of a retired target not served by its replacement: code=%d", rec.Code)
+	}
+
+	clock.Set(start.Add(3 * time.Minute))
+	if indexes := targetIndexes(t, f); len(indexes) != 4 || indexes["8"] == "" || indexes["9"] == "" {
+		t.Errorf("unexpected targets after three rounds of churn: targets=%v", indexes)
+	}
+}
+
+func TestFarmTargetGrowth(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := generator.NewVirtualClock(start)
+	f, err := NewFarm(FarmOptions{
+		Simulator: "hosts",
+		Targets:   2,
+		SimulatorOptions: generator.SimulatorOptions{
+			Targets:   1,
+			Start:     start,
+			TimeNowFn: clock.Now,
+		},
+		TargetGrowth: generator.Growth{
+			Mode:     generator.GrowthLinear,
+			Factor:   1,
+			Every:    time.Minute,
+			MaxHosts: 5,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := f.ActiveSeries()
+
+	clock.Set(start.Add(time.Minute))
+	if n := f.Targets(); n != 4 {
+		t.Errorf("unexpected targets after growth: targets=%d", n)
+	}
+	if indexes := targetIndexes(t, f); indexes["2"] != "2" || indexes["3"] != "3" {
+		t.Errorf("grown targets not in new slots: targets=%v", indexes)
+	}
+	if f.ActiveSeries() != 2*active {
+		t.Errorf("grown targets not counted: active=%d, expected=%d", f.ActiveSeries(), 2*active)
+	}
+
+	clock.Set(start.Add(time.Hour))
+	if n := f.Targets(); n != 5 {
+		t.Errorf("growth not capped: targets=%d", n)
+	}
+}
+
+func TestFarmValidatesTargetOptions(t *testing.T) {
+	for _, opts := range []FarmOptions{
+		{Simulator: "hosts", Targets: 1, TargetChurn: 1.5, TargetChurnEvery: time.Minute},
+		{Simulator: "hosts", Targets: 1, TargetChurn: 0.5},
+		{Simulator: "hosts", Targets: 1, TargetGrowth: generator.Growth{Mode: "quadratic"}},
+	} {
+		if _, err := NewFarm(opts); err == nil {
+			t.Errorf("expected invalid farm options error: opts=%+v", opts)
+		}
+	}
+}
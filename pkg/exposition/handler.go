@@ -0,0 +1,162 @@
+package exposition
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/generator"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	textContentType        = "text/plain; version=0.0.4; charset=utf-8"
+
+	defaultScrapeInterval = 15 * time.Second
+)
+
+type HandlerOptions struct {
+	// ScrapeInterval is how far the simulation progresses per scrape.
+	ScrapeInterval time.Duration
+	// NewSeriesPercent is the fraction [0.0,1.0] of series churned at the
+	// end of every pass over the simulated targets.
+	NewSeriesPercent float64
+	// ChurnSchedule when set replaces NewSeriesPercent with the churn of
+	// the phase in effect since the handler was created.
+	ChurnSchedule generator.ChurnSchedule
+	// Metadata writes HELP and TYPE lines, and UNIT lines in OpenMetrics,
+	// for simulators that describe their metric families. The series of a
+	// scrape are then buffered to group them by family, as they always are
+	// when serving OpenMetrics.
+	Metadata bool
+}
+
+// Handler serves a simulator's series at /metrics, each scrape progressing
+// the simulation by a full scrape interval so that every target is scraped
+// once. It serves OpenMetrics to scrapers that accept it and the Prometheus
+// text format otherwise.
+type Handler struct {
+	sim     generator.Simulator
+	opts    HandlerOptions
+	created time.Time
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+func NewHandler(sim generator.Simulator, opts HandlerOptions) *Handler {
+	if opts.ScrapeInterval <= 0 {
+		opts.ScrapeInterval = defaultScrapeInterval
+	}
+	return &Handler{
+		sim:     sim,
+		opts:    opts,
+		created: time.Now(),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", textContentType)
+	}
+
+	var (
+		buf     = bufio.NewWriter(w)
+		batch   = make([]prompb.TimeSeries, 1)
+		written bool
+		err     error
+	)
+	// OpenMetrics requires the series of a family to be contiguous, which
+	// simulators emitting target by target interleave
+	if h.opts.Metadata || openMetrics {
+		err = h.writeFamilies(buf, openMetrics)
+		written = err == nil
+	} else {
+		err = h.sim.GenerateStream(h.opts.ScrapeInterval, h.opts.ScrapeInterval,
+			h.newSeriesPercent(), func(series prompb.TimeSeries) error {
+				written = true
+				batch[0] = series
+				return WriteOpenMetrics(buf, batch, false)
+			})
+	}
+	if err != nil {
+		if !written {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Part of the exposition may have been sent, abort the connection
+		// so the scrape fails rather than ingesting a partial exposition
+		panic(http.ErrAbortHandler)
+	}
+	if openMetrics {
+		WriteOpenMetricsEOF(buf)
+	}
+	buf.Flush()
+}
+
+func (h *Handler) newSeriesPercent() float64 {
+	if len(h.opts.ChurnSchedule) > 0 {
+		return h.opts.ChurnSchedule.At(time.Since(h.created))
+	}
+	return h.opts.NewSeriesPercent
+}
+
+// writeFamilies generates a scrape and writes it grouped by metric family,
+// each family preceded by its metadata if configured. Families are those
+// described by simulators that describe them, and metric names otherwise.
+// Metadata is looked up once the scrape has been generated as simulators
+// hold their lock while generating.
+func (h *Handler) writeFamilies(w *bufio.Writer, openMetrics bool) error {
+	var (
+		names  []string
+		byName = make(map[string][]prompb.TimeSeries)
+	)
+	err := h.sim.GenerateStream(h.opts.ScrapeInterval, h.opts.ScrapeInterval,
+		h.newSeriesPercent(), func(s prompb.TimeSeries) error {
+			name := metricName(s.Labels)
+			if _, ok := byName[name]; !ok {
+				names = append(names, name)
+			}
+			byName[name] = append(byName[name], s)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	var (
+		families []generator.MetricMetadata
+		series   [][]prompb.TimeSeries
+		byFamily = make(map[string]int)
+	)
+	sim, describes := h.sim.(generator.MetadataSimulator)
+	for _, name := range names {
+		metadata := generator.MetricMetadata{Family: name, Type: generator.MetricTypeUnknown}
+		if describes {
+			metadata = sim.MetricMetadata(name)
+		}
+		i, ok := byFamily[metadata.Family]
+		if !ok {
+			i = len(families)
+			byFamily[metadata.Family] = i
+			families = append(families, metadata)
+			series = append(series, nil)
+		}
+		series[i] = append(series[i], byName[name]...)
+	}
+
+	for i, metadata := range families {
+		if h.opts.Metadata && describes {
+			WriteMetricMetadata(w, metadata, openMetrics)
+		}
+		if err := WriteOpenMetrics(w, series[i], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
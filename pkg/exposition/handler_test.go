@@ -0,0 +1,217 @@
+package exposition
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/generator"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// staticSimulator emits the same series on every scrape, or fails with err.
+type staticSimulator struct {
+	series  []prompb.TimeSeries
+	err     error
+	scrapes int
+}
+
+var _ generator.MetadataSimulator = (*staticSimulator)(nil)
+
+func (s *staticSimulator) Generate(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+) (generator.SeriesBatch, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return generator.SeriesBatch{"static": s.series}, nil
+}
+
+func (s *staticSimulator) GenerateStream(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+	fn func(series prompb.TimeSeries) error,
+) error {
+	s.scrapes++
+	if s.err != nil {
+		return s.err
+	}
+	for _, series := range s.series {
+		if err := fn(series); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *staticSimulator) ActiveSeries() int {
+	return len(s.series)
+}
+
+func (s *staticSimulator) Churn(newSeriesPercent float64) error {
+	return nil
+}
+
+func (s *staticSimulator) MetricMetadata(name string) generator.MetricMetadata {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(name, suffix) {
+			return generator.MetricMetadata{
+				Family: strings.TrimSuffix(name, suffix),
+				Type:   generator.MetricTypeHistogram,
+			}
+		}
+	}
+	return generator.MetricMetadata{
+		Family: name,
+		Type:   generator.MetricTypeGauge,
+		Help:   "Help of " + name + ".",
+	}
+}
+
+func testSeries(name string, v float64, labelValues ...string) prompb.TimeSeries {
+	seriesLabels := []prompb.Label{{Name: labels.MetricName, Value: name}}
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		seriesLabels = append(seriesLabels, prompb.Label{Name: labelValues[i], Value: labelValues[i+1]})
+	}
+	return prompb.TimeSeries{
+		Labels:  seriesLabels,
+		Samples: []prompb.Sample{{Value: v, Timestamp: 1000}},
+	}
+}
+
+// interleavedSeries emits the series of two families interleaved, as
+// simulators emit them per target.
+func interleavedSeries() []prompb.TimeSeries {
+	return []prompb.TimeSeries{
+		testSeries("cpu", 1, "host", "a"),
+		testSeries("latency_bucket", 2, "host", "a", "le", "+Inf"),
+		testSeries("latency_count", 2, "host", "a"),
+		testSeries("cpu", 3, "host", "b"),
+		testSeries("latency_bucket", 4, "host", "b", "le", "+Inf"),
+		testSeries("latency_count", 4, "host", "b"),
+	}
+}
+
+func serve(h http.Handler, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerTextFormat(t *testing.T) {
+	sim := &staticSimulator{series: []prompb.TimeSeries{
+		testSeries("cpu", 1.5, "host", "a\"b"),
+		{
+			Labels:  []prompb.Label{{Name: labels.MetricName, Value: "gone"}},
+			Samples: []prompb.Sample{{Value: math.Float64frombits(value.StaleNaN)}},
+		},
+		testSeries("mem", math.Inf(1)),
+	}}
+	rec := serve(NewHandler(sim, HandlerOptions{}), "text/plain")
+
+	if ct := rec.Header().Get("Content-Type"); ct != textContentType {
+		t.Errorf("unexpected content type: value=%s", ct)
+	}
+	expected := "cpu{host=\"a\\\"b\"} 1.5\nmem +Inf\n"
+	if body := rec.Body.String(); body != expected {
+		t.Errorf("unexpected exposition:\nexpected=%q\nactual=%q", expected, body)
+	}
+	if sim.scrapes != 1 {
+		t.Errorf("expected one scrape generated: scrapes=%d", sim.scrapes)
+	}
+}
+
+func TestHandlerOpenMetrics(t *testing.T) {
+	sim := &staticSimulator{series: []prompb.TimeSeries{testSeries("cpu", 1)}}
+	rec := serve(NewHandler(sim, HandlerOptions{}),
+		"application/openmetrics-text;version=1.0.0,text/plain;q=0.5")
+
+	if ct := rec.Header().Get("Content-Type"); ct != openMetricsContentType {
+		t.Errorf("unexpected content type: value=%s", ct)
+	}
+	if body := rec.Body.String(); body != "cpu 1\n# EOF\n" {
+		t.Errorf("unexpected exposition: body=%q", body)
+	}
+}
+
+func TestHandlerMetadataGroupsFamilies(t *testing.T) {
+	sim := &staticSimulator{series: interleavedSeries()}
+	rec := serve(NewHandler(sim, HandlerOptions{Metadata: true}), "")
+
+	expected := "# HELP cpu Help of cpu.\n" +
+		"# TYPE cpu gauge\n" +
+		"cpu{host=\"a\"} 1\n" +
+		"cpu{host=\"b\"} 3\n" +
+		"# TYPE latency histogram\n" +
+		"latency_bucket{host=\"a\",le=\"+Inf\"} 2\n" +
+		"latency_bucket{host=\"b\",le=\"+Inf\"} 4\n" +
+		"latency_count{host=\"a\"} 2\n" +
+		"latency_count{host=\"b\"} 4\n"
+	if body := rec.Body.String(); body != expected {
+		t.Errorf("unexpected exposition:\nexpected=%q\nactual=%q", expected, body)
+	}
+}
+
+func TestHandlerOpenMetricsGroupsFamilies(t *testing.T) {
+	sim := &staticSimulator{series: interleavedSeries()}
+	rec := serve(NewHandler(sim, HandlerOptions{}), "application/openmetrics-text")
+
+	expected := "cpu{host=\"a\"} 1\n" +
+		"cpu{host=\"b\"} 3\n" +
+		"latency_bucket{host=\"a\",le=\"+Inf\"} 2\n" +
+		"latency_bucket{host=\"b\",le=\"+Inf\"} 4\n" +
+		"latency_count{host=\"a\"} 2\n" +
+		"latency_count{host=\"b\"} 4\n" +
+		"# EOF\n"
+	if body := rec.Body.String(); body != expected {
+		t.Errorf("unexpected exposition:\nexpected=%q\nactual=%q", expected, body)
+	}
+
+	// Without metadata the text format streams the series as emitted
+	rec = serve(NewHandler(sim, HandlerOptions{}), "")
+	if body := rec.Body.String(); !strings.HasPrefix(body, "cpu{host=\"a\"} 1\nlatency_bucket") {
+		t.Errorf("text format series not streamed as emitted: body=%q", body)
+	}
+}
+
+func TestHandlerErrorBeforeWriting(t *testing.T) {
+	sim := &staticSimulator{err: errors.New("max series created exceeded")}
+	rec := serve(NewHandler(sim, HandlerOptions{}), "")
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status: code=%d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "max series created exceeded") {
+		t.Errorf("error not returned: body=%q", rec.Body.String())
+	}
+}
+
+func TestHandlerChurnSchedule(t *testing.T) {
+	h := NewHandler(&staticSimulator{}, HandlerOptions{
+		NewSeriesPercent: 0.5,
+		ChurnSchedule: generator.ChurnSchedule{
+			{After: 0, Churn: 0.1},
+			{After: time.Hour, Churn: 0.9},
+		},
+	})
+	if churn := h.newSeriesPercent(); churn != 0.1 {
+		t.Errorf("churn schedule not in effect: churn=%v", churn)
+	}
+
+	h = NewHandler(&staticSimulator{}, HandlerOptions{NewSeriesPercent: 0.5})
+	if churn := h.newSeriesPercent(); churn != 0.5 {
+		t.Errorf("unexpected churn: churn=%v", churn)
+	}
+}
@@ -0,0 +1,150 @@
+package exposition
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/generator"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// WriteOpenMetrics writes the series' samples as OpenMetrics text lines,
+// including sample timestamps if requested. Without timestamps only the
+// latest sample of each series is written, as a scrape would see it.
+// Staleness markers have no representation in the text format and are
+// skipped.
+func WriteOpenMetrics(
+	w *bufio.Writer,
+	series []prompb.TimeSeries,
+	timestamps bool,
+) error {
+	for _, s := range series {
+		for i, sample := range s.Samples {
+			if value.IsStaleNaN(sample.Value) {
+				continue
+			}
+			if !timestamps && i != len(s.Samples)-1 {
+				continue
+			}
+			writeSeriesName(w, s.Labels)
+			w.WriteByte(' ')
+			w.WriteString(formatValue(sample.Value))
+			if timestamps {
+				w.WriteByte(' ')
+				w.WriteString(formatTimestamp(sample.Timestamp))
+			}
+			if err := w.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteOpenMetricsEOF terminates an OpenMetrics exposition.
+func WriteOpenMetricsEOF(w io.Writer) error {
+	_, err := io.WriteString(w, "# EOF\n")
+	return err
+}
+
+// WriteMetricMetadata writes the HELP and TYPE lines of a family, and its
+// UNIT line in OpenMetrics. OpenMetrics counters are named without their
+// _total suffix, and ones without it are written as unknown.
+func WriteMetricMetadata(
+	w *bufio.Writer,
+	metadata generator.MetricMetadata,
+	openMetrics bool,
+) {
+	family, metricType := metadata.Family, metadata.Type
+	if openMetrics && metricType == generator.MetricTypeCounter {
+		if strings.HasSuffix(family, "_total") {
+			family = strings.TrimSuffix(family, "_total")
+		} else {
+			metricType = generator.MetricTypeUnknown
+		}
+	}
+	if !openMetrics && metricType == generator.MetricTypeUnknown {
+		// The text format calls unknown untyped
+		metricType = "untyped"
+	}
+
+	if metadata.Help != "" {
+		w.WriteString("# HELP ")
+		w.WriteString(family)
+		w.WriteByte(' ')
+		helpEscaper.WriteString(w, metadata.Help)
+		w.WriteByte('\n')
+	}
+	w.WriteString("# TYPE ")
+	w.WriteString(family)
+	w.WriteByte(' ')
+	w.WriteString(string(metricType))
+	w.WriteByte('\n')
+	if openMetrics && metadata.Unit != "" && strings.HasSuffix(family, "_"+metadata.Unit) {
+		w.WriteString("# UNIT ")
+		w.WriteString(family)
+		w.WriteByte(' ')
+		w.WriteString(metadata.Unit)
+		w.WriteByte('\n')
+	}
+}
+
+func metricName(seriesLabels []prompb.Label) string {
+	for _, l := range seriesLabels {
+		if l.Name == labels.MetricName {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+func writeSeriesName(w *bufio.Writer, seriesLabels []prompb.Label) {
+	w.WriteString(metricName(seriesLabels))
+	first := true
+	for _, l := range seriesLabels {
+		if l.Name == labels.MetricName {
+			continue
+		}
+		if first {
+			w.WriteByte('{')
+			first = false
+		} else {
+			w.WriteByte(',')
+		}
+		w.WriteString(l.Name)
+		w.WriteString(`="`)
+		labelValueEscaper.WriteString(w, l.Value)
+		w.WriteByte('"')
+	}
+	if !first {
+		w.WriteByte('}')
+	}
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatTimestamp formats a millisecond timestamp as OpenMetrics seconds.
+func formatTimestamp(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', 3, 64)
+}
@@ -0,0 +1,288 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// BlendSimulator runs several simulators side by side as one composite
+// workload, e.g. devops hosts alongside Kubernetes churn, concatenating
+// their series so they share a single writer pipeline.
+type BlendSimulator struct {
+	sync.Mutex
+	components []BlendComponent
+	// elapsed is the simulated time progressed by all calls to generate,
+	// the clock component churn schedules follow.
+	elapsed time.Duration
+	// names maps every metric name generated to the index of the component
+	// emitting it, to resolve metadata and sources. It has its own lock so
+	// that it can be read while generating.
+	namesLock sync.RWMutex
+	names     map[string]int
+}
+
+// BlendComponent is a simulator of a blend.
+type BlendComponent struct {
+	// Name prefixes the component's SeriesBatch keys so that targets of
+	// different components never merge, it must be unique in the blend.
+	Name      string
+	Simulator Simulator
+	// ChurnSchedule when set replaces the churn passed to Generate for this
+	// component with the churn of the phase in effect, measured in
+	// simulated time progressed since the blend was created.
+	ChurnSchedule ChurnSchedule
+}
+
+const blendKeySeparator = "/"
+
+func NewBlendSimulator(components []BlendComponent) (*BlendSimulator, error) {
+	if len(components) == 0 {
+		return nil, fmt.Errorf("blend has no components")
+	}
+	seen := make(map[string]struct{}, len(components))
+	for _, c := range components {
+		if _, ok := seen[c.Name]; ok {
+			return nil, fmt.Errorf("duplicate blend component: name=%s", c.Name)
+		}
+		seen[c.Name] = struct{}{}
+	}
+	return &BlendSimulator{
+		components: components,
+		names:      make(map[string]int),
+	}, nil
+}
+
+// Churn immediately replaces the given fraction [0.0,1.0] of series of every
+// component.
+func (b *BlendSimulator) Churn(newSeriesPercent float64) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, c := range b.components {
+		if err := c.Simulator.Churn(newSeriesPercent); err != nil {
+			return fmt.Errorf("could not churn blend component: name=%s, err=%v", c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (b *BlendSimulator) ActiveSeries() int {
+	b.Lock()
+	defer b.Unlock()
+
+	active := 0
+	for _, c := range b.components {
+		active += c.Simulator.ActiveSeries()
+	}
+	return active
+}
+
+// DuplicateSeries returns the duplicate series detected by the components
+// that detect them.
+func (b *BlendSimulator) DuplicateSeries() DuplicateSeriesStats {
+	b.Lock()
+	defer b.Unlock()
+
+	var stats DuplicateSeriesStats
+	for _, c := range b.components {
+		if sim, ok := c.Simulator.(DuplicateSeriesSimulator); ok {
+			stats.Add(sim.DuplicateSeries())
+		}
+	}
+	return stats
+}
+
+func (b *BlendSimulator) Generate(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+) (SeriesBatch, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	result := make(SeriesBatch)
+	for i, c := range b.components {
+		batch, err := c.Simulator.Generate(progressBy, scrapeDuration,
+			b.newSeriesPercentWithLock(c, newSeriesPercent))
+		if err != nil {
+			return nil, fmt.Errorf("could not generate blend component: name=%s, err=%w", c.Name, err)
+		}
+		for key, series := range batch {
+			for _, s := range series {
+				b.recordName(blendMetricName(s.Labels), i)
+			}
+			result[c.Name+blendKeySeparator+key] = series
+		}
+	}
+	b.elapsed += progressBy
+	return result, nil
+}
+
+func (b *BlendSimulator) GenerateStream(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+	fn func(series prompb.TimeSeries) error,
+) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for i, c := range b.components {
+		index := i
+		// Errors of fn are returned as is so callers can stop the stream
+		// with their own sentinels
+		var fnErr error
+		err := c.Simulator.GenerateStream(progressBy, scrapeDuration,
+			b.newSeriesPercentWithLock(c, newSeriesPercent),
+			func(series prompb.TimeSeries) error {
+				b.recordName(blendMetricName(series.Labels), index)
+				fnErr = fn(series)
+				return fnErr
+			})
+		if err != nil && err == fnErr {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("could not generate blend component: name=%s, err=%w", c.Name, err)
+		}
+	}
+	b.elapsed += progressBy
+	return nil
+}
+
+func (b *BlendSimulator) newSeriesPercentWithLock(c BlendComponent, newSeriesPercent float64) float64 {
+	if len(c.ChurnSchedule) > 0 {
+		return c.ChurnSchedule.At(b.elapsed)
+	}
+	return newSeriesPercent
+}
+
+func (b *BlendSimulator) recordName(name string, index int) {
+	b.namesLock.RLock()
+	recorded, ok := b.names[name]
+	b.namesLock.RUnlock()
+	if ok && recorded == index {
+		return
+	}
+
+	b.namesLock.Lock()
+	b.names[name] = index
+	b.namesLock.Unlock()
+}
+
+func (b *BlendSimulator) component(name string) (BlendComponent, bool) {
+	b.namesLock.RLock()
+	defer b.namesLock.RUnlock()
+
+	index, ok := b.names[name]
+	if !ok {
+		return BlendComponent{}, false
+	}
+	return b.components[index], true
+}
+
+func blendMetricName(seriesLabels []prompb.Label) string {
+	if i := metricNameIndex(seriesLabels); i >= 0 {
+		return seriesLabels[i].Value
+	}
+	return ""
+}
+
+// MetricMetadata returns the metadata of the component that last generated
+// series with the given name, unknown if it has not been generated or the
+// component does not describe its families.
+func (b *BlendSimulator) MetricMetadata(name string) MetricMetadata {
+	if c, ok := b.component(name); ok {
+		if sim, ok := c.Simulator.(MetadataSimulator); ok {
+			return sim.MetricMetadata(name)
+		}
+	}
+	return MetricMetadata{Family: name, Type: MetricTypeUnknown}
+}
+
+// Source returns the name of the component that last generated series
+// with the metric name of the given series, empty if none has, for
+// attributing sender stats to components.
+func (b *BlendSimulator) Source(series prompb.TimeSeries) string {
+	c, _ := b.component(blendMetricName(series.Labels))
+	return c.Name
+}
+
+// newBlendSimulator constructs the "blend" simulator from params: components
+// lists the simulators to blend as comma separated <simulator>:<weight>,
+// e.g. "hosts:3,kubernetes:1", the targets being split between them by
+// weight. Params prefixed with "<simulator>." are passed to that simulator,
+// and "<simulator>.churn_schedule" is its ChurnSchedule in the
+// ParseChurnSchedule format.
+func newBlendSimulator(opts SimulatorOptions) (Simulator, error) {
+	spec, ok := opts.Params["components"]
+	if !ok {
+		return nil, fmt.Errorf("missing simulator param: name=components")
+	}
+
+	var (
+		names       []string
+		weights     []float64
+		totalWeight float64
+	)
+	for _, component := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(component), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("blend component must be <simulator>:<weight>: value=%s", component)
+		}
+		weight, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid blend component weight: value=%s, err=%v", component, err)
+		}
+		if parts[0] == "blend" {
+			return nil, fmt.Errorf("blend component cannot be a blend: value=%s", component)
+		}
+		names = append(names, parts[0])
+		weights = append(weights, weight)
+		totalWeight += weight
+	}
+
+	components := make([]BlendComponent, 0, len(names))
+	for i, name := range names {
+		share := weights[i] / totalWeight
+		simOpts := SimulatorOptions{
+			Targets:   int(math.Max(1, math.Round(share*float64(opts.Targets)))),
+			Start:     opts.Start,
+			TimeNowFn: opts.TimeNowFn,
+			Params:    make(map[string]string),
+		}
+		if opts.Seed != 0 {
+			simOpts.Seed = opts.Seed + int64(i)
+		}
+		if opts.MaxSeriesCreated > 0 {
+			simOpts.MaxSeriesCreated = int(math.Max(1, share*float64(opts.MaxSeriesCreated)))
+		}
+		prefix := name + "."
+		for param, value := range opts.Params {
+			if strings.HasPrefix(param, prefix) {
+				simOpts.Params[strings.TrimPrefix(param, prefix)] = value
+			}
+		}
+
+		c := BlendComponent{Name: name}
+		if str, ok := simOpts.Params["churn_schedule"]; ok {
+			schedule, err := ParseChurnSchedule(str)
+			if err != nil {
+				return nil, fmt.Errorf("invalid blend component churn schedule: name=%s, err=%v", name, err)
+			}
+			c.ChurnSchedule = schedule
+			delete(simOpts.Params, "churn_schedule")
+		}
+		sim, err := NewSimulator(name, simOpts)
+		if err != nil {
+			return nil, err
+		}
+		c.Simulator = sim
+		components = append(components, c)
+	}
+	return NewBlendSimulator(components)
+}
@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func newTestBlend(t *testing.T, params map[string]string) Simulator {
+	t.Helper()
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if params["components"] == "" {
+		params["components"] = "hosts:1,kubernetes:1"
+	}
+	sim, err := NewSimulator("blend", SimulatorOptions{
+		Targets:   2,
+		Start:     start,
+		TimeNowFn: func() time.Time { return start },
+		Seed:      1,
+		Params:    params,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+func TestBlendGenerateStreamReturnsCallbackErrors(t *testing.T) {
+	sim := newTestBlend(t, map[string]string{})
+	errStop := errors.New("stop")
+
+	n := 0
+	err := sim.GenerateStream(10*time.Second, 10*time.Second, 0,
+		func(series prompb.TimeSeries) error {
+			n++
+			if n == 3 {
+				return errStop
+			}
+			return nil
+		})
+	if err != errStop {
+		t.Fatalf("expected the callback's error unwrapped: err=%v", err)
+	}
+	if n != 3 {
+		t.Errorf("stream not stopped at the callback's error: series=%d", n)
+	}
+}
+
+func TestBlendWrapsComponentErrors(t *testing.T) {
+	sim := newTestBlend(t, map[string]string{})
+
+	err := sim.GenerateStream(10*time.Second, 10*time.Second, 2,
+		func(series prompb.TimeSeries) error { return nil })
+	if err == nil {
+		t.Fatal("expected an invalid newSeriesPercent error")
+	}
+	if !strings.Contains(err.Error(), "could not generate blend component: name=hosts") {
+		t.Errorf("component error not wrapped: err=%v", err)
+	}
+	if errors.Unwrap(err) == nil {
+		t.Errorf("component error not wrapped with %%w: err=%v", err)
+	}
+}
+
+func TestBlendAttributesSeriesToComponents(t *testing.T) {
+	sim := newTestBlend(t, map[string]string{})
+	source := sim.(*BlendSimulator)
+
+	sources := make(map[string]int)
+	err := sim.GenerateStream(10*time.Second, 10*time.Second, 0,
+		func(series prompb.TimeSeries) error {
+			sources[source.Source(series)]++
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sources["hosts"] == 0 || sources["kubernetes"] == 0 || len(sources) != 2 {
+		t.Errorf("unexpected series per source: sources=%v", sources)
+	}
+	if active, total := sim.ActiveSeries(), sources["hosts"]+sources["kubernetes"]; active != total {
+		t.Errorf("active series do not match a full pass: active=%d, generated=%d", active, total)
+	}
+}
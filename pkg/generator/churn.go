@@ -0,0 +1,61 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChurnPhase churns Churn, a fraction [0.0,1.0] of series per pass over the
+// simulated targets, from After into a run until the next phase.
+type ChurnPhase struct {
+	After time.Duration
+	Churn float64
+}
+
+// ChurnSchedule varies churn over a run so that it can ramp, spike at
+// deploy time and return to baseline, its phases sorted by After.
+type ChurnSchedule []ChurnPhase
+
+// ParseChurnSchedule parses a schedule written as comma separated
+// <after>:<churn> phases, e.g. "0s:0.01,10m:0.2,12m:0.01".
+func ParseChurnSchedule(s string) (ChurnSchedule, error) {
+	var schedule ChurnSchedule
+	for _, phase := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(phase), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("churn phase must be <after>:<churn>: value=%s", phase)
+		}
+		after, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid churn phase start: value=%s, err=%v", phase, err)
+		}
+		churn, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid churn phase churn: value=%s, err=%v", phase, err)
+		}
+		if churn < 0 || churn > 1 {
+			return nil, fmt.Errorf("churn phase churn not between [0.0,1.0]: value=%s", phase)
+		}
+		schedule = append(schedule, ChurnPhase{After: after, Churn: churn})
+	}
+	sort.SliceStable(schedule, func(i, j int) bool {
+		return schedule[i].After < schedule[j].After
+	})
+	return schedule, nil
+}
+
+// At returns the churn of the phase in effect the given time into a run,
+// zero before the first phase.
+func (s ChurnSchedule) At(elapsed time.Duration) float64 {
+	churn := 0.0
+	for _, phase := range s {
+		if phase.After > elapsed {
+			break
+		}
+		churn = phase.Churn
+	}
+	return churn
+}
@@ -0,0 +1,31 @@
+package generator
+
+import (
+	"sync"
+	"time"
+)
+
+// VirtualClock is a TimeNowFn for simulators driven through simulated
+// time, e.g. when backfilling, rather than wall time.
+type VirtualClock struct {
+	sync.RWMutex
+	now time.Time
+}
+
+func NewVirtualClock(now time.Time) *VirtualClock {
+	return &VirtualClock{now: now}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.now
+}
+
+func (c *VirtualClock) Set(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = now
+}
@@ -0,0 +1,156 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// LabelDistributionUniform assigns the values of the pool to hosts
+	// round-robin by host index, so every value is assigned to as many
+	// hosts as every other, give or take one.
+	LabelDistributionUniform = "uniform"
+	// LabelDistributionZipf assigns the value of rank k to hosts in
+	// proportion to 1/k^ZipfExponent, a few values being very common and
+	// most rare.
+	LabelDistributionZipf = "zipf"
+	// LabelDistributionConstant assigns the first value to every host.
+	LabelDistributionConstant = "constant"
+
+	defaultZipfExponent = 1
+)
+
+// LabelDistribution controls the values of a label, drawn per host from a
+// pool of Pool values named "<label>_<rank>" so that every series of a host
+// carries the same value.
+type LabelDistribution struct {
+	Pool int
+	// Distribution is LabelDistributionUniform, the default,
+	// LabelDistributionZipf or LabelDistributionConstant.
+	Distribution string
+	// ZipfExponent is the skew of a Zipf distribution, defaults to 1.
+	ZipfExponent float64
+}
+
+// ParseLabelDistribution parses a distribution written as
+// "<distribution>:<pool>[:<zipf exponent>]", e.g. "zipf:1000:1.2",
+// "uniform:50" or "constant:1".
+func ParseLabelDistribution(s string) (LabelDistribution, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return LabelDistribution{}, fmt.Errorf(
+			"label distribution must be <distribution>:<pool>[:<zipf exponent>]: value=%s", s)
+	}
+	pool, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return LabelDistribution{}, fmt.Errorf("invalid label distribution pool: value=%s, err=%v",
+			s, err)
+	}
+	d := LabelDistribution{Pool: pool, Distribution: parts[0]}
+	if len(parts) == 3 {
+		d.ZipfExponent, err = strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return LabelDistribution{}, fmt.Errorf("invalid label distribution exponent: value=%s, err=%v",
+				s, err)
+		}
+	}
+	return d, nil
+}
+
+type labelDistribution struct {
+	name string
+	pool int
+	// cdf is the cumulative probability of each rank, nil when uniform.
+	cdf      []float64
+	constant bool
+}
+
+// compileLabelDistributions validates the distributions and precomputes
+// them, sorted by label name so that labels are always added in the same
+// order.
+func compileLabelDistributions(distributions map[string]LabelDistribution) ([]labelDistribution, error) {
+	names := make([]string, 0, len(distributions))
+	for name := range distributions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	compiled := make([]labelDistribution, 0, len(names))
+	for _, name := range names {
+		d := distributions[name]
+		if d.Pool <= 0 {
+			return nil, fmt.Errorf("label distribution pool must be positive: name=%s, pool=%d",
+				name, d.Pool)
+		}
+		c := labelDistribution{name: name, pool: d.Pool}
+		switch d.Distribution {
+		case "", LabelDistributionUniform:
+		case LabelDistributionConstant:
+			c.constant = true
+		case LabelDistributionZipf:
+			exponent := d.ZipfExponent
+			if exponent <= 0 {
+				exponent = defaultZipfExponent
+			}
+			c.cdf = make([]float64, d.Pool)
+			total := 0.0
+			for k := range c.cdf {
+				total += 1 / math.Pow(float64(k+1), exponent)
+				c.cdf[k] = total
+			}
+			for k := range c.cdf {
+				c.cdf[k] /= total
+			}
+		default:
+			return nil, fmt.Errorf("unknown label distribution: name=%s, distribution=%s, supported=%v",
+				name, d.Distribution, []string{LabelDistributionUniform,
+					LabelDistributionZipf, LabelDistributionConstant})
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// rank returns the rank of the value assigned to the host, derived from the
+// host index so that it is stable across scrapes and runs. Zipf ranks are
+// drawn by hashing the host index so that common values are spread across
+// hosts rather than assigned to the first ones.
+func (d labelDistribution) rank(hostIndex int) int {
+	if d.constant {
+		return 0
+	}
+	if d.cdf == nil {
+		return hostIndex % d.pool
+	}
+	u := fingerprintFraction(seriesFingerprint([]prompb.Label{{
+		Name:  d.name,
+		Value: strconv.Itoa(hostIndex),
+	}}))
+	rank := sort.SearchFloat64s(d.cdf, u)
+	if rank >= d.pool {
+		rank = d.pool - 1
+	}
+	return rank
+}
+
+func renderLabelDistributions(
+	distributions []labelDistribution,
+	hostIndex int,
+) []prompb.Label {
+	if len(distributions) == 0 {
+		return nil
+	}
+	result := make([]prompb.Label, 0, len(distributions))
+	for _, d := range distributions {
+		result = append(result, prompb.Label{
+			Name:  d.name,
+			Value: d.name + "_" + strconv.Itoa(d.rank(hostIndex)),
+		})
+	}
+	return result
+}
@@ -0,0 +1,30 @@
+package generator
+
+import (
+	"testing"
+)
+
+func TestUniformLabelDistributionSplitsEvenly(t *testing.T) {
+	distributions, err := compileLabelDistributions(map[string]LabelDistribution{
+		"zone": {Pool: 3, Distribution: LabelDistributionUniform},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := make(map[string]int)
+	for hostIndex := 0; hostIndex < 10; hostIndex++ {
+		for _, l := range renderLabelDistributions(distributions, hostIndex) {
+			hosts[l.Value]++
+		}
+	}
+	expected := map[string]int{"zone_0": 4, "zone_1": 3, "zone_2": 3}
+	if len(hosts) != len(expected) {
+		t.Fatalf("unexpected values: hosts=%v", hosts)
+	}
+	for value, n := range expected {
+		if hosts[value] != n {
+			t.Errorf("values not split evenly: value=%s, hosts=%d, expected=%d", value, hosts[value], n)
+		}
+	}
+}
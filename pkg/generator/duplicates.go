@@ -0,0 +1,172 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// DuplicateSeriesReport counts series whose label set another host
+	// already emits, e.g. because custom Labels templates overwrite the
+	// hostname, so that cardinality ground truth can be corrected.
+	DuplicateSeriesReport = "report"
+	// DuplicateSeriesFail makes generating return an error at the first
+	// duplicate series.
+	DuplicateSeriesFail = "fail"
+	// DuplicateSeriesUniquify reports duplicates and sets the
+	// DuplicateSeriesLabel on them to the emitting host, making them unique.
+	DuplicateSeriesUniquify = "uniquify"
+
+	DuplicateSeriesLabel = "duplicate_host"
+
+	maxDuplicateSeriesExamples = 10
+)
+
+// DuplicateSeriesStats are the duplicate series detected since the
+// simulator was created.
+type DuplicateSeriesStats struct {
+	// Series is the number of duplicate series emitted, counted once per
+	// scrape.
+	Series int64
+	// Active is the number of duplicate series among those currently
+	// emitted, as of the last scrape of each host.
+	Active int64
+	// Examples are the first few duplicate label sets.
+	Examples []string
+}
+
+// DuplicateSeriesSimulator is a Simulator that detects series duplicated
+// across its hosts.
+type DuplicateSeriesSimulator interface {
+	Simulator
+	// DuplicateSeries returns the duplicate series detected since the
+	// simulator was created.
+	DuplicateSeries() DuplicateSeriesStats
+}
+
+var (
+	_ DuplicateSeriesSimulator = (*HostsSimulator)(nil)
+	_ DuplicateSeriesSimulator = (*BlendSimulator)(nil)
+)
+
+// Add adds other's duplicates, keeping at most maxDuplicateSeriesExamples.
+func (s *DuplicateSeriesStats) Add(other DuplicateSeriesStats) {
+	s.Series += other.Series
+	s.Active += other.Active
+	for _, example := range other.Examples {
+		if len(s.Examples) >= maxDuplicateSeriesExamples {
+			break
+		}
+		s.Examples = append(s.Examples, example)
+	}
+}
+
+// duplicateSeriesFnWithLock wraps fn to detect series with the same label
+// set as a series of another active host. Staleness markers are passed
+// through unchanged as retired hosts may share labels with their
+// replacements. Series the label bomb applies to are never duplicates, as
+// the bomb's value is unique to the host and scrape, so they are not
+// tracked; otherwise every bombed series would be recorded.
+func (h *HostsSimulator) duplicateSeriesFnWithLock(
+	fn func(key string, series prompb.TimeSeries) error,
+) func(key string, series prompb.TimeSeries) error {
+	if h.duplicateSeries == "" {
+		return fn
+	}
+
+	return func(key string, series prompb.TimeSeries) error {
+		if len(series.Samples) > 0 && value.IsStaleNaN(series.Samples[0].Value) {
+			return fn(key, series)
+		}
+
+		if h.labelBomb.Label != "" && h.labelBombMetric(series.Labels) {
+			return fn(key, series)
+		}
+
+		sorted := append([]prompb.Label(nil), series.Labels...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Name < sorted[j].Name
+		})
+		fingerprint := seriesFingerprint(sorted)
+		owner, ok := h.seriesOwners[fingerprint]
+		// Hosts retired by churn no longer have families emitted
+		_, ownerActive := h.familiesEmitted[owner]
+		if !ok || owner == key || !ownerActive {
+			if owner != key {
+				h.seriesOwners[fingerprint] = key
+				h.ownedSeries[key] = append(h.ownedSeries[key], fingerprint)
+				delete(h.duplicatedSeries[key], fingerprint)
+			}
+			return fn(key, series)
+		}
+
+		if h.duplicateSeries == DuplicateSeriesFail {
+			return fmt.Errorf("duplicate series across hosts: host=%s, owner=%s, labels=%v",
+				key, owner, sorted)
+		}
+		h.duplicates.Series++
+		duplicated := h.duplicatedSeries[key]
+		if duplicated == nil {
+			duplicated = make(map[uint64]struct{})
+			h.duplicatedSeries[key] = duplicated
+		}
+		duplicated[fingerprint] = struct{}{}
+		if len(h.duplicates.Examples) < maxDuplicateSeriesExamples {
+			h.duplicates.Examples = append(h.duplicates.Examples, fmt.Sprint(sorted))
+		}
+		if h.duplicateSeries == DuplicateSeriesUniquify {
+			seriesLabels := append([]prompb.Label(nil), series.Labels...)
+			series.Labels = setLabels(seriesLabels, []prompb.Label{{
+				Name:  DuplicateSeriesLabel,
+				Value: key,
+			}})
+		}
+		return fn(key, series)
+	}
+}
+
+// releaseOwnedSeriesWithLock forgets the series owned and duplicated by a
+// retired host.
+func (h *HostsSimulator) releaseOwnedSeriesWithLock(key string) {
+	for _, fingerprint := range h.ownedSeries[key] {
+		if h.seriesOwners[fingerprint] == key {
+			delete(h.seriesOwners, fingerprint)
+		}
+	}
+	delete(h.ownedSeries, key)
+	delete(h.duplicatedSeries, key)
+}
+
+// activeDuplicatesWithLock returns the number of series hosts emitted in
+// their last scrape that another active host already emits.
+func (h *HostsSimulator) activeDuplicatesWithLock() int {
+	active := 0
+	for _, duplicated := range h.duplicatedSeries {
+		active += len(duplicated)
+	}
+	return active
+}
+
+func validateDuplicateSeries(mode string) error {
+	switch mode {
+	case "", DuplicateSeriesReport, DuplicateSeriesFail, DuplicateSeriesUniquify:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate series mode: mode=%s, supported=%v",
+		mode, []string{DuplicateSeriesReport, DuplicateSeriesFail, DuplicateSeriesUniquify})
+}
+
+// DuplicateSeries returns the duplicate series detected, always zero unless
+// the DuplicateSeries option is set.
+func (h *HostsSimulator) DuplicateSeries() DuplicateSeriesStats {
+	h.RLock()
+	defer h.RUnlock()
+
+	stats := h.duplicates
+	stats.Active = int64(h.activeDuplicatesWithLock())
+	stats.Examples = append([]string(nil), h.duplicates.Examples...)
+	return stats
+}
@@ -0,0 +1,96 @@
+package generator
+
+import (
+	"testing"
+)
+
+// duplicateLabels overwrite every host tag, so all hosts emit the same
+// series.
+var duplicateLabels = map[string]string{
+	"hostname":            "x",
+	"region":              "x",
+	"datacenter":          "x",
+	"rack":                "x",
+	"os":                  "x",
+	"arch":                "x",
+	"team":                "x",
+	"service":             "x",
+	"service_version":     "x",
+	"service_environment": "x",
+}
+
+func TestDuplicateSeriesReport(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 10, clock, HostsSimulatorOptions{
+		Labels:          duplicateLabels,
+		DuplicateSeries: DuplicateSeriesReport,
+	})
+	perHost := sim.seriesPerHost
+
+	series := scrape(t, sim, clock, 0)
+	if len(series) != 10*perHost {
+		t.Fatalf("duplicates not emitted when reporting: series=%d", len(series))
+	}
+	stats := sim.DuplicateSeries()
+	if stats.Series != int64(9*perHost) || stats.Active != int64(9*perHost) {
+		t.Errorf("unexpected duplicates: stats=%+v, expected=%d", stats, 9*perHost)
+	}
+	if len(stats.Examples) != maxDuplicateSeriesExamples {
+		t.Errorf("unexpected examples: examples=%d", len(stats.Examples))
+	}
+	if active := sim.ActiveSeries(); active != perHost {
+		t.Errorf("duplicates counted as active series: active=%d, expected=%d", active, perHost)
+	}
+
+	scrape(t, sim, clock, 0)
+	if stats := sim.DuplicateSeries(); stats.Series != int64(18*perHost) ||
+		stats.Active != int64(9*perHost) {
+		t.Errorf("unexpected duplicates after a second scrape: stats=%+v", stats)
+	}
+}
+
+func TestDuplicateSeriesUniquify(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 3, clock, HostsSimulatorOptions{
+		Labels:          duplicateLabels,
+		DuplicateSeries: DuplicateSeriesUniquify,
+	})
+
+	keys := make(map[string]struct{})
+	series := scrape(t, sim, clock, 0)
+	for _, s := range series {
+		keys[seriesKey(s.Labels)] = struct{}{}
+	}
+	if len(keys) != len(series) {
+		t.Errorf("duplicates not made unique: unique=%d, series=%d", len(keys), len(series))
+	}
+	if stats := sim.DuplicateSeries(); stats.Series != int64(2*sim.seriesPerHost) {
+		t.Errorf("uniquified duplicates not reported: stats=%+v", stats)
+	}
+}
+
+func TestDuplicateSeriesFail(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 2, clock, HostsSimulatorOptions{
+		Labels:          duplicateLabels,
+		DuplicateSeries: DuplicateSeriesFail,
+	})
+
+	_, err := sim.Generate(testScrapeInterval, testScrapeInterval, 0)
+	if err == nil {
+		t.Fatal("expected a duplicate series error")
+	}
+}
+
+func TestNoDuplicateSeriesWithoutOverlappingLabels(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 10, clock, HostsSimulatorOptions{
+		DuplicateSeries: DuplicateSeriesFail,
+	})
+	for i := 0; i < 3; i++ {
+		scrape(t, sim, clock, 0.5)
+	}
+	if stats := sim.DuplicateSeries(); stats.Series != 0 {
+		t.Errorf("unexpected duplicates: stats=%+v", stats)
+	}
+}
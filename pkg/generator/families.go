@@ -0,0 +1,98 @@
+package generator
+
+import (
+	"sort"
+	"time"
+)
+
+type MetricFamilyClass int
+
+const (
+	// MetricFamilyClassNormal families are emitted unless disabled.
+	MetricFamilyClassNormal MetricFamilyClass = iota
+	// MetricFamilyClassCritical families are always emitted and ignore
+	// toggles.
+	MetricFamilyClassCritical
+	// MetricFamilyClassDebug families are only emitted during debug windows.
+	MetricFamilyClassDebug
+)
+
+type TimeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (w TimeWindow) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+type MetricFamilyToggle struct {
+	At      time.Time
+	Family  string
+	Enabled bool
+}
+
+func sortedMetricFamilyToggles(toggles []MetricFamilyToggle) []MetricFamilyToggle {
+	result := append([]MetricFamilyToggle{}, toggles...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].At.Before(result[j].At)
+	})
+	return result
+}
+
+// AddDebugWindow schedules an additional window during which debug metric
+// families are emitted, e.g. to model verbose metrics toggled on during an
+// incident.
+func (h *HostsSimulator) AddDebugWindow(w TimeWindow) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.debugWindows = append(h.debugWindows, w)
+}
+
+// SetMetricFamilyEnabled enables or disables a metric family across all
+// hosts, disabled families are marked stale on each host's next scrape.
+func (h *HostsSimulator) SetMetricFamilyEnabled(family string, enabled bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.setMetricFamilyEnabledWithLock(family, enabled)
+}
+
+func (h *HostsSimulator) setMetricFamilyEnabledWithLock(family string, enabled bool) {
+	if enabled {
+		delete(h.familiesDisabled, family)
+	} else {
+		h.familiesDisabled[family] = struct{}{}
+	}
+}
+
+func (h *HostsSimulator) applyMetricFamilyTogglesWithLock(now time.Time) {
+	for len(h.familyToggles) > 0 && !h.familyToggles[0].At.After(now) {
+		toggle := h.familyToggles[0]
+		h.setMetricFamilyEnabledWithLock(toggle.Family, toggle.Enabled)
+		h.familyToggles = h.familyToggles[1:]
+	}
+}
+
+func (h *HostsSimulator) debugActiveWithLock(now time.Time) bool {
+	for _, w := range h.debugWindows {
+		if w.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HostsSimulator) emitMetricFamilyWithLock(family string, debugActive bool) bool {
+	switch h.metricFamilyClasses[family] {
+	case MetricFamilyClassCritical:
+		return true
+	case MetricFamilyClassDebug:
+		if !debugActive {
+			return false
+		}
+	}
+	_, disabled := h.familiesDisabled[family]
+	return !disabled
+}
@@ -3,37 +3,280 @@ package generator
 import (
 	"fmt"
 	"math"
+	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/influxdata/influxdb-comparisons/bulk_data_gen/common"
 	"github.com/influxdata/influxdb-comparisons/bulk_data_gen/devops"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
 	"github.com/prometheus/prometheus/prompb"
 )
 
 type HostsSimulator struct {
 	sync.RWMutex
-	hosts     []devops.Host
-	allHosts  []devops.Host
-	hostIndex int
-	timeNowFn func() time.Time
+	hosts           []simulatedHost
+	allHosts        []simulatedHost
+	hostIndex       int
+	start           time.Time
+	clusters        []string
+	initialHosts    int
+	growth          Growth
+	labelBomb       LabelBomb
+	duplicateSeries string
+	duplicates      DuplicateSeriesStats
+	// seriesOwners maps the fingerprint of every series to the host that
+	// first emitted it, and ownedSeries the reverse, when detecting
+	// duplicate series. duplicatedSeries are the fingerprints each host
+	// emits that another host owns.
+	seriesOwners        map[uint64]string
+	ownedSeries         map[string][]uint64
+	duplicatedSeries    map[string]map[uint64]struct{}
+	clusterLabel        string
+	labelTemplates      []labelTemplate
+	labelDistributions  []labelDistribution
+	shardID             int
+	targetActiveSeries  int
+	seriesPerHost       int
+	nameCollisions      map[string]string
+	rng                 *rand.Rand
+	nanPercent          float64
+	infPercent          float64
+	valueRanges         map[string]ValueRange
+	integerPercent      float64
+	constantPercent     float64
+	slowPercent         float64
+	slowEvery           int
+	heldValues          map[string]map[uint64]*heldValue
+	counterFamilies     map[string]struct{}
+	counterResetPercent float64
+	counterValues       map[string]map[uint64]float64
+	histograms          []HistogramFamily
+	histogramStates     map[string][][]*histogramState
+	staleOnChurn        bool
+	// constantChurn replaces hosts on every call to generate rather than
+	// at the end of every pass, from constantChurnPosition onwards.
+	constantChurn         bool
+	constantChurnCarry    float64
+	constantChurnPosition int
+	maxSeriesCreated      int
+	seriesCreated         int
+	pendingStale          SeriesBatch
+	labelRenames          []LabelRename
+	metricRenames         []MetricRename
+	metricRenameStates    map[string][]metricRenameState
+	labelRenameStates     map[string][]labelRenameState
+	timeNowFn             func() time.Time
+	metricFamilyClasses   map[string]MetricFamilyClass
+	debugWindows          []TimeWindow
+	familyToggles         []MetricFamilyToggle
+	familiesDisabled      map[string]struct{}
+	familiesEmitted       map[string]map[string]struct{}
 }
 
 type HostsSimulatorOptions struct {
-	Labels    map[string]string
+	// Labels are added to every series, replacing any label of the same
+	// name. Values are text/template templates executed with the host's
+	// LabelTemplateData, NewHostsSimulator returns an error if one is
+	// invalid.
+	Labels map[string]string
+	// LabelDistributions are labels whose values are drawn per host from a
+	// pool with the given distribution, replacing any label of the same name
+	// including Labels.
+	LabelDistributions map[string]LabelDistribution
+	// ShardID identifies this generator instance among several generating
+	// load for the same run, for use in Labels templates.
+	ShardID   int
 	TimeNowFn func() time.Time
+	// Seed when non-zero makes the hosts and value shaping of runs with the
+	// same options reproducible. Each simulator draws from its own source,
+	// the devops measurements still drawing their values from the global
+	// math/rand source.
+	Seed int64
+	// MetricFamilyClasses assigns a class to metric families by measurement
+	// name (e.g. "cpu", "diskio"), families not listed are normal.
+	MetricFamilyClasses map[string]MetricFamilyClass
+	// DebugWindows are the time ranges during which debug metric families
+	// are emitted.
+	DebugWindows []TimeWindow
+	// MetricFamilyToggles are scheduled enables/disables of metric families
+	// across the whole fleet.
+	MetricFamilyToggles []MetricFamilyToggle
+	// Clusters when set simulates that many clusters, each with its own
+	// population of hostCount hosts and a distinct cluster label value.
+	Clusters int
+	// ClusterLabel is the external label name identifying the cluster,
+	// defaults to "cluster".
+	ClusterLabel string
+	// ClusterOverlapPercent is the fraction [0.0,1.0] of each cluster's
+	// hosts whose series are identical to the first cluster's except for
+	// the cluster label.
+	ClusterOverlapPercent float64
+	// MetricNameCollisions emits families keyed by measurement name under
+	// the mapped __name__ instead, with a different label set (hostname and
+	// the measurement's own tags only), to model teams colliding on metric
+	// names.
+	MetricNameCollisions map[string]string
+	// NaNPercent is the fraction [0.0,1.0] of samples emitted as NaN.
+	NaNPercent float64
+	// InfPercent is the fraction [0.0,1.0] of samples emitted as +Inf or
+	// -Inf, split evenly between the two. NaNPercent and InfPercent sum to at
+	// most one.
+	InfPercent float64
+	// ValueRanges overrides the values of families keyed by measurement
+	// name with values drawn from the given range.
+	ValueRanges map[string]ValueRange
+	// IntegerSeriesPercent is the fraction [0.0,1.0] of series whose values
+	// are always whole numbers, as is typical of counters and counts.
+	IntegerSeriesPercent float64
+	// ConstantSeriesPercent is the fraction [0.0,1.0] of series whose value
+	// never changes, modeling info-style gauges.
+	ConstantSeriesPercent float64
+	// SlowChangingSeriesPercent is the fraction [0.0,1.0] of series whose
+	// value only changes every SlowChangingEvery scrapes.
+	// ConstantSeriesPercent and SlowChangingSeriesPercent sum to at most
+	// one.
+	SlowChangingSeriesPercent float64
+	SlowChangingEvery         int
+	// TargetActiveSeries when set overrides the host count with however
+	// many hosts it takes to emit exactly this many active series with all
+	// metric families enabled, the last host emitting only a subset of its
+	// series.
+	TargetActiveSeries int
+	// CounterFamilies are measurement names whose series are emitted as
+	// counters, accumulating the simulated values.
+	CounterFamilies []string
+	// CounterResetPercent is the fraction [0.0,1.0] of scrapes of a host at
+	// which its counters reset to zero, simulating a process restart.
+	CounterResetPercent float64
+	// HistogramFamilies are classic histograms and summaries every host
+	// emits in addition to its measurements.
+	HistogramFamilies []HistogramFamily
+	// StaleMarkersOnChurn emits a staleness marker for every series of each
+	// host retired by churn, before its replacement is first scraped.
+	StaleMarkersOnChurn bool
+	// ConstantChurn replaces the churned fraction of hosts on every call to
+	// Generate, rather than all at once at the end of every pass over the
+	// hosts, so that the churn rate is constant and exact while the number
+	// of active series never changes. Hosts are replaced in rotation across
+	// the fleet, so this erodes ClusterOverlapPercent.
+	ConstantChurn bool
+	// MaxSeriesCreated when set is a safety cap on the cumulative number of
+	// series created, initially, by churn and growth, by the label bomb and
+	// by metric and label renames, once exceeded generating and churning
+	// return an error.
+	MaxSeriesCreated int
+	// Growth grows the number of hosts over the run, NewHostsSimulator
+	// returns an error if it is invalid. Hosts beyond TargetActiveSeries
+	// emit no series, so the two should not be combined.
+	Growth Growth
+	// LabelBomb gives a label a fresh unique value on every scrape.
+	LabelBomb LabelBomb
+	// DuplicateSeries when set detects series with the same label set as
+	// another host's, one of DuplicateSeriesReport, DuplicateSeriesFail or
+	// DuplicateSeriesUniquify. NewHostsSimulator returns an error if it is
+	// invalid.
+	DuplicateSeries string
+	// LabelRenames are scheduled label key migrations.
+	LabelRenames []LabelRename
+	// MetricRenames are scheduled metric name migrations.
+	MetricRenames []MetricRename
+}
+
+const defaultSlowChangingEvery = 10
+
+const defaultClusterLabel = "cluster"
+
+type simulatedHost struct {
+	devops.Host
+	cluster string
+	labels  []prompb.Label
+}
+
+// key uniquely identifies the host across clusters.
+func (h simulatedHost) key() string {
+	if h.cluster == "" {
+		return string(h.Name)
+	}
+	return h.cluster + "/" + string(h.Name)
 }
 
 func NewHostsSimulator(
 	hostCount int,
 	start time.Time,
 	opts HostsSimulatorOptions,
-) *HostsSimulator {
-	var hosts []devops.Host
+) (*HostsSimulator, error) {
+	seed := time.Now().UnixNano()
+	if opts.Seed != 0 {
+		seed = opts.Seed
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	labelTemplates, err := parseLabelTemplates(opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+	labelDistributions, err := compileLabelDistributions(opts.LabelDistributions)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.Growth.Validate(); err != nil {
+		return nil, err
+	}
+	if err := validateDuplicateSeries(opts.DuplicateSeries); err != nil {
+		return nil, err
+	}
+	if err := validateValueFractions(opts); err != nil {
+		return nil, err
+	}
+
+	clusters := []string{""}
+	if opts.Clusters > 0 {
+		clusters = make([]string, 0, opts.Clusters)
+		for i := 0; i < opts.Clusters; i++ {
+			clusters = append(clusters, fmt.Sprintf("cluster_%d", i))
+		}
+	}
+
+	seriesPerHost := 0
+	for _, measurement := range devops.NewHostMeasurements(start) {
+		p := common.MakeUsablePoint()
+		measurement.ToPoint(p)
+		seriesPerHost += len(p.FieldKeys)
+	}
+	histograms := make([]HistogramFamily, 0, len(opts.HistogramFamilies))
+	for _, family := range opts.HistogramFamilies {
+		family = family.withDefaults()
+		histograms = append(histograms, family)
+		seriesPerHost += family.Series * family.seriesPerLabelSet()
+	}
+	if opts.TargetActiveSeries > 0 {
+		hosts := (opts.TargetActiveSeries + seriesPerHost - 1) / seriesPerHost
+		hostCount = (hosts + len(clusters) - 1) / len(clusters)
+	}
+
+	// Overlapping hosts come first so that churn, which replaces hosts from
+	// the tail, only erodes the overlap once it exceeds the rest
+	overlapHosts := int(math.Ceil(opts.ClusterOverlapPercent * float64(hostCount)))
+
+	// Interleave clusters so that churn is spread evenly across all clusters
+	var hosts []simulatedHost
 	for i := 0; i < hostCount; i++ {
-		host := devops.NewHost(i, 0, start)
-		hosts = append(hosts, host)
+		var first devops.Host
+		for j, cluster := range clusters {
+			hostIndex := i * len(clusters)
+			host := newHost(rng, hostIndex+j, start)
+			if j == 0 {
+				first = host
+			} else if i < overlapHosts {
+				host = cloneHostTags(first, start)
+			} else {
+				hostIndex += j
+			}
+			hosts = append(hosts, newSimulatedHost(host, cluster,
+				labelTemplates, labelDistributions, hostIndex, opts.ShardID))
+		}
 	}
 
 	timeNowFn := time.Now
@@ -41,59 +284,405 @@ func NewHostsSimulator(
 		timeNowFn = opts.TimeNowFn
 	}
 
+	slowEvery := defaultSlowChangingEvery
+	if opts.SlowChangingEvery > 0 {
+		slowEvery = opts.SlowChangingEvery
+	}
+
+	clusterLabel := defaultClusterLabel
+	if opts.ClusterLabel != "" {
+		clusterLabel = opts.ClusterLabel
+	}
+
+	counterFamilies := make(map[string]struct{}, len(opts.CounterFamilies))
+	for _, family := range opts.CounterFamilies {
+		counterFamilies[family] = struct{}{}
+	}
+
 	return &HostsSimulator{
-		hosts:     hosts,
-		allHosts:  hosts,
-		hostIndex: hostCount,
-		timeNowFn: timeNowFn,
+		hosts:               hosts,
+		allHosts:            hosts,
+		hostIndex:           len(hosts),
+		start:               start,
+		clusters:            clusters,
+		initialHosts:        hostCount,
+		growth:              opts.Growth,
+		labelBomb:           opts.LabelBomb,
+		duplicateSeries:     opts.DuplicateSeries,
+		seriesOwners:        make(map[uint64]string),
+		ownedSeries:         make(map[string][]uint64),
+		duplicatedSeries:    make(map[string]map[uint64]struct{}),
+		clusterLabel:        clusterLabel,
+		labelTemplates:      labelTemplates,
+		labelDistributions:  labelDistributions,
+		shardID:             opts.ShardID,
+		targetActiveSeries:  opts.TargetActiveSeries,
+		seriesPerHost:       seriesPerHost,
+		nameCollisions:      opts.MetricNameCollisions,
+		rng:                 rng,
+		nanPercent:          opts.NaNPercent,
+		infPercent:          opts.InfPercent,
+		valueRanges:         opts.ValueRanges,
+		integerPercent:      opts.IntegerSeriesPercent,
+		constantPercent:     opts.ConstantSeriesPercent,
+		slowPercent:         opts.SlowChangingSeriesPercent,
+		slowEvery:           slowEvery,
+		heldValues:          make(map[string]map[uint64]*heldValue),
+		counterFamilies:     counterFamilies,
+		counterResetPercent: opts.CounterResetPercent,
+		counterValues:       make(map[string]map[uint64]float64),
+		histograms:          histograms,
+		histogramStates:     make(map[string][][]*histogramState),
+		staleOnChurn:        opts.StaleMarkersOnChurn,
+		constantChurn:       opts.ConstantChurn,
+		maxSeriesCreated:    opts.MaxSeriesCreated,
+		seriesCreated:       len(hosts) * seriesPerHost,
+		pendingStale:        make(SeriesBatch),
+		labelRenames:        append([]LabelRename{}, opts.LabelRenames...),
+		metricRenames:       append([]MetricRename{}, opts.MetricRenames...),
+		metricRenameStates:  make(map[string][]metricRenameState),
+		labelRenameStates:   make(map[string][]labelRenameState),
+		timeNowFn:           timeNowFn,
+		metricFamilyClasses: opts.MetricFamilyClasses,
+		debugWindows:        append([]TimeWindow{}, opts.DebugWindows...),
+		familyToggles:       sortedMetricFamilyToggles(opts.MetricFamilyToggles),
+		familiesDisabled:    make(map[string]struct{}),
+		familiesEmitted:     make(map[string]map[string]struct{}),
+	}, nil
+}
+
+func newSimulatedHost(
+	host devops.Host,
+	cluster string,
+	labelTemplates []labelTemplate,
+	labelDistributions []labelDistribution,
+	hostIndex int,
+	shardID int,
+) simulatedHost {
+	hostLabels := renderLabelTemplates(labelTemplates, LabelTemplateData{
+		HostIndex: hostIndex,
+		ShardID:   shardID,
+		Hostname:  string(host.Name),
+		Cluster:   cluster,
+	})
+	return simulatedHost{
+		Host:    host,
+		cluster: cluster,
+		labels: setLabels(hostLabels,
+			renderLabelDistributions(labelDistributions, hostIndex)),
 	}
 }
 
+// newHost returns a devops host with its tags drawn from the given source
+// rather than the global one devops.NewHost draws from.
+func newHost(rng *rand.Rand, i int, start time.Time) devops.Host {
+	choice := func(choices [][]byte) []byte {
+		return choices[rng.Intn(len(choices))]
+	}
+	region := &devops.Regions[rng.Intn(len(devops.Regions))]
+	return devops.Host{
+		Name:                  []byte(fmt.Sprintf("host_%d", i)),
+		Region:                region.Name,
+		Datacenter:            choice(region.Datacenters),
+		Rack:                  []byte(fmt.Sprintf("%d", rng.Int63n(devops.MachineRackChoicesPerDatacenter))),
+		Arch:                  choice(devops.MachineArchChoices),
+		OS:                    choice(devops.MachineOSChoices),
+		Service:               []byte(fmt.Sprintf("%d", rng.Int63n(devops.MachineServiceChoices))),
+		ServiceVersion:        []byte(fmt.Sprintf("%d", rng.Int63n(devops.MachineServiceVersionChoices))),
+		ServiceEnvironment:    choice(devops.MachineServiceEnvironmentChoices),
+		Team:                  choice(devops.MachineTeamChoices),
+		SimulatedMeasurements: devops.NewHostMeasurements(start),
+	}
+}
+
+// cloneHostTags returns a host with the same tags as the given host but its
+// own independently simulated measurements.
+func cloneHostTags(host devops.Host, start time.Time) devops.Host {
+	host.SimulatedMeasurements = devops.NewHostMeasurements(start)
+	return host
+}
+
 func (h *HostsSimulator) nextHostIndexWithLock() int {
 	v := h.hostIndex
 	h.hostIndex++
 	return v
 }
 
+func (h *HostsSimulator) seriesLabelsWithLock(
+	host simulatedHost,
+	p *common.Point,
+	fieldName []byte,
+) []prompb.Label {
+	var seriesLabels []prompb.Label
+	if name, ok := h.nameCollisions[string(p.MeasurementName)]; ok {
+		seriesLabels = make([]prompb.Label, 0, 3+len(p.TagKeys))
+		seriesLabels = append(seriesLabels,
+			prompb.Label{Name: labels.MetricName, Value: name},
+			prompb.Label{Name: "measurement", Value: string(fieldName)},
+			prompb.Label{Name: string(devops.MachineTagKeys[0]), Value: string(host.Name)},
+		)
+		for i, tagKey := range p.TagKeys {
+			seriesLabels = append(seriesLabels, prompb.Label{
+				Name:  string(tagKey),
+				Value: string(p.TagValues[i]),
+			})
+		}
+	} else {
+		seriesLabels = []prompb.Label{
+			prompb.Label{Name: labels.MetricName, Value: string(p.MeasurementName)},
+			prompb.Label{Name: "measurement", Value: string(fieldName)},
+			prompb.Label{Name: string(devops.MachineTagKeys[0]), Value: string(host.Name)},
+			prompb.Label{Name: string(devops.MachineTagKeys[1]), Value: string(host.Region)},
+			prompb.Label{Name: string(devops.MachineTagKeys[2]), Value: string(host.Datacenter)},
+			prompb.Label{Name: string(devops.MachineTagKeys[3]), Value: string(host.Rack)},
+			prompb.Label{Name: string(devops.MachineTagKeys[4]), Value: string(host.OS)},
+			prompb.Label{Name: string(devops.MachineTagKeys[5]), Value: string(host.Arch)},
+			prompb.Label{Name: string(devops.MachineTagKeys[6]), Value: string(host.Team)},
+			prompb.Label{Name: string(devops.MachineTagKeys[7]), Value: string(host.Service)},
+			prompb.Label{Name: string(devops.MachineTagKeys[8]), Value: string(host.ServiceVersion)},
+			prompb.Label{Name: string(devops.MachineTagKeys[9]), Value: string(host.ServiceEnvironment)},
+		}
+	}
+	if host.cluster != "" {
+		seriesLabels = append(seriesLabels, prompb.Label{Name: h.clusterLabel, Value: host.cluster})
+	}
+	return setLabels(seriesLabels, host.labels)
+}
+
 func (h *HostsSimulator) Hosts() []devops.Host {
 	h.RLock()
 	defer h.RUnlock()
 
-	return append([]devops.Host{}, h.hosts...)
+	hosts := make([]devops.Host, 0, len(h.hosts))
+	for _, host := range h.hosts {
+		hosts = append(hosts, host.Host)
+	}
+	return hosts
+}
+
+// Churn immediately replaces the given fraction [0.0,1.0] of hosts with new
+// ones, rather than waiting for the end of a full pass over the hosts.
+func (h *HostsSimulator) Churn(newSeriesPercent float64) error {
+	h.Lock()
+	defer h.Unlock()
+
+	if newSeriesPercent < 0 || newSeriesPercent > 1 {
+		return fmt.Errorf(
+			"newSeriesPercent not between [0.0,1.0]: value=%v",
+			newSeriesPercent)
+	}
+
+	return h.churnWithLock(newSeriesPercent, h.timeNowFn())
+}
+
+func (h *HostsSimulator) churnWithLock(newSeriesPercent float64, now time.Time) error {
+	if newSeriesPercent <= 0 {
+		return nil
+	}
+
+	remove := int(math.Ceil(newSeriesPercent * float64(len(h.allHosts))))
+	return h.replaceHostsWithLock(len(h.allHosts)-remove, remove, now)
+}
+
+// constantChurnWithLock replaces the fraction of hosts due this call,
+// carrying the remainder over to the next so that the rate is exact, at the
+// positions following the last replaced so that every host lives as long.
+func (h *HostsSimulator) constantChurnWithLock(newSeriesPercent float64, now time.Time) error {
+	if newSeriesPercent <= 0 || len(h.allHosts) == 0 {
+		return nil
+	}
+
+	h.constantChurnCarry += newSeriesPercent * float64(len(h.allHosts))
+	replace := int(h.constantChurnCarry)
+	if replace > len(h.allHosts) {
+		replace = len(h.allHosts)
+	}
+	h.constantChurnCarry -= float64(replace)
+	if replace == 0 {
+		return nil
+	}
+
+	start := h.constantChurnPosition
+	h.constantChurnPosition = (start + replace) % len(h.allHosts)
+	if tail := len(h.allHosts) - start; replace > tail {
+		if err := h.replaceHostsWithLock(start, tail, now); err != nil {
+			return err
+		}
+		return h.replaceHostsWithLock(0, replace-tail, now)
+	}
+	return h.replaceHostsWithLock(start, replace, now)
+}
+
+// replaceHostsWithLock replaces count hosts from the given position in
+// allHosts with new hosts of the same clusters.
+func (h *HostsSimulator) replaceHostsWithLock(position, count int, now time.Time) error {
+	if err := checkSeriesCreated(
+		h.seriesCreated, count*h.seriesPerHost, h.maxSeriesCreated,
+	); err != nil {
+		return err
+	}
+	h.seriesCreated += count * h.seriesPerHost
+
+	// Hosts are replaced in place so that the remainder of the current pass
+	// sees the new hosts
+	for i := position; i < position+count; i++ {
+		host := h.allHosts[i]
+		if h.staleOnChurn {
+			if stale := h.staleSeriesWithLock(host, i, now); len(stale) > 0 {
+				h.pendingStale[host.key()] = stale
+			}
+		}
+		delete(h.familiesEmitted, host.key())
+		delete(h.heldValues, host.key())
+		delete(h.counterValues, host.key())
+		delete(h.histogramStates, host.key())
+		delete(h.metricRenameStates, host.key())
+		delete(h.labelRenameStates, host.key())
+		h.releaseOwnedSeriesWithLock(host.key())
+
+		newHostIndex := h.nextHostIndexWithLock()
+		h.allHosts[i] = newSimulatedHost(newHost(h.rng, newHostIndex, now), host.cluster,
+			h.labelTemplates, h.labelDistributions, newHostIndex, h.shardID)
+	}
+	return nil
+}
+
+// ActiveSeries returns the number of series currently emitted per pass over
+// all hosts. When reporting duplicate series those emitted by more than one
+// host are counted once.
+func (h *HostsSimulator) ActiveSeries() int {
+	h.RLock()
+	defer h.RUnlock()
+
+	if len(h.allHosts) == 0 {
+		return 0
+	}
+
+	debugActive := h.debugActiveWithLock(h.timeNowFn())
+	var emitted []bool
+	for _, measurement := range h.allHosts[0].SimulatedMeasurements {
+		p := common.MakeUsablePoint()
+		measurement.ToPoint(p)
+		emit := h.emitMetricFamilyWithLock(string(p.MeasurementName), debugActive)
+		for range p.FieldKeys {
+			emitted = append(emitted, emit)
+		}
+	}
+	for _, family := range h.histograms {
+		emit := h.emitMetricFamilyWithLock(family.Name, debugActive)
+		for i := 0; i < family.Series*family.seriesPerLabelSet(); i++ {
+			emitted = append(emitted, emit)
+		}
+	}
+
+	active := 0
+	for i := range h.allHosts {
+		budget := h.seriesBudgetWithLock(i)
+		for j, emit := range emitted {
+			if j >= budget {
+				break
+			}
+			if emit {
+				active++
+			}
+		}
+	}
+	if h.duplicateSeries == DuplicateSeriesReport {
+		active -= h.activeDuplicatesWithLock()
+	}
+	return active
+}
+
+// seriesBudgetWithLock returns how many of its series, in the order they
+// are generated, the host at the given position in allHosts emits.
+func (h *HostsSimulator) seriesBudgetWithLock(position int) int {
+	if h.targetActiveSeries <= 0 {
+		return math.MaxInt32
+	}
+	budget := h.targetActiveSeries - position*h.seriesPerHost
+	if budget < 0 {
+		return 0
+	}
+	return budget
 }
 
 func (h *HostsSimulator) Generate(
 	progressBy, scrapeDuration time.Duration,
 	newSeriesPercent float64,
-) (map[string][]prompb.TimeSeries, error) {
+) (SeriesBatch, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	hostValues := make(SeriesBatch)
+	err := h.generateWithLock(progressBy, scrapeDuration, newSeriesPercent,
+		func(key string, series prompb.TimeSeries) error {
+			hostValues[key] = append(hostValues[key], series)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return hostValues, nil
+}
+
+// GenerateStream progresses the simulation like Generate but passes each
+// series to fn as it is produced rather than returning them all at once,
+// it stops at and returns the first error returned by fn. The simulator is
+// locked while fn is called.
+func (h *HostsSimulator) GenerateStream(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+	fn func(series prompb.TimeSeries) error,
+) error {
 	h.Lock()
 	defer h.Unlock()
 
+	return h.generateWithLock(progressBy, scrapeDuration, newSeriesPercent,
+		func(_ string, series prompb.TimeSeries) error {
+			return fn(series)
+		})
+}
+
+func (h *HostsSimulator) generateWithLock(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+	fn func(key string, series prompb.TimeSeries) error,
+) error {
 	if newSeriesPercent < 0 || newSeriesPercent > 1 {
-		return nil, fmt.Errorf(
+		return fmt.Errorf(
 			"newSeriesPercent not between [0.0,1.0]: value=%v",
 			newSeriesPercent)
 	}
 
+	if err := checkSeriesCreated(h.seriesCreated, 0, h.maxSeriesCreated); err != nil {
+		return err
+	}
+
+	fn = h.duplicateSeriesFnWithLock(h.labelBombFnWithLock(fn))
+
 	now := h.timeNowFn()
+	if err := h.growWithLock(now); err != nil {
+		return err
+	}
 	factorProgress := float64(progressBy) / float64(scrapeDuration)
 	numHosts := int(math.Ceil(factorProgress * float64(len(h.allHosts))))
 	if numHosts == 0 {
 		// Always progress by at least one
 		numHosts = 1
 	}
+	if h.constantChurn {
+		if err := h.constantChurnWithLock(newSeriesPercent, now); err != nil {
+			return err
+		}
+	}
 	if len(h.hosts) == 0 {
 		// Out of hosts, remove/add hosts as needed and progress ticking
 		for _, host := range h.allHosts {
 			host.TickAll(progressBy)
 		}
-		if newSeriesPercent > 0 {
-			remove := int(math.Ceil(newSeriesPercent * float64(len(h.allHosts))))
-			h.allHosts = h.allHosts[:len(h.allHosts)-remove]
-			for i := 0; i < remove; i++ {
-				newHostIndex := h.nextHostIndexWithLock()
-				newHost := devops.NewHost(newHostIndex, 0, now)
-				h.allHosts = append(h.allHosts, newHost)
+		if !h.constantChurn {
+			if err := h.churnWithLock(newSeriesPercent, now); err != nil {
+				return err
 			}
 		}
 		// Reset hosts
@@ -105,20 +694,74 @@ func (h *HostsSimulator) Generate(
 
 	// Select hosts
 	sendFromHosts := h.hosts[:numHosts]
+	sendFromPosition := len(h.allHosts) - len(h.hosts)
 
 	// Progress hosts
 	h.hosts = h.hosts[numHosts:]
 
 	nowUnixMilliseconds := now.UnixNano() / int64(time.Millisecond)
+	debugActive := h.debugActiveWithLock(now)
+	h.applyMetricFamilyTogglesWithLock(now)
+	staleNaN := math.Float64frombits(value.StaleNaN)
 
-	hostValues := make(map[string][]prompb.TimeSeries)
-	for _, host := range sendFromHosts {
-		allSeries := make([]prompb.TimeSeries, 0, len(host.SimulatedMeasurements))
+	// Mark series of hosts retired since the last scrape stale
+	pendingStale := h.pendingStale
+	if len(pendingStale) > 0 {
+		h.pendingStale = make(SeriesBatch)
+	}
+	for key, staleSeries := range pendingStale {
+		for _, series := range staleSeries {
+			series.Samples[0].Timestamp = nowUnixMilliseconds
+			if err := fn(key, series); err != nil {
+				return err
+			}
+		}
+	}
+
+	for position, host := range sendFromHosts {
+		budget := h.seriesBudgetWithLock(sendFromPosition + position)
+		fieldIndex := 0
+		emitted := h.familiesEmitted[host.key()]
+		if emitted == nil {
+			emitted = make(map[string]struct{})
+			h.familiesEmitted[host.key()] = emitted
+		}
+		held := h.heldValues[host.key()]
+		if held == nil {
+			held = make(map[uint64]*heldValue)
+			h.heldValues[host.key()] = held
+		}
+		renameStates := h.metricRenameStatesWithLock(host)
+		labelRenameStates := h.labelRenameStatesWithLock(host)
+		counters := h.hostCountersWithLock(host)
 		for _, measurement := range host.SimulatedMeasurements {
 			p := common.MakeUsablePoint()
 			measurement.ToPoint(p)
 
+			familyIndex := fieldIndex
+			fieldIndex += len(p.FieldKeys)
+
+			family := string(p.MeasurementName)
+			valueRange, hasValueRange := h.valueRanges[family]
+			_, isCounter := h.counterFamilies[family]
+			_, wasEmitted := emitted[family]
+			emit := h.emitMetricFamilyWithLock(family, debugActive)
+			if !emit && !wasEmitted {
+				continue
+			}
+			if emit {
+				emitted[family] = struct{}{}
+			} else {
+				// Family switched off since the last scrape, mark the
+				// series stale one last time
+				delete(emitted, family)
+			}
+
 			for i, fieldName := range p.FieldKeys {
+				if familyIndex+i >= budget {
+					break
+				}
+
 				val := 0.0
 
 				switch v := p.FieldValues[i].(type) {
@@ -132,34 +775,68 @@ func (h *HostsSimulator) Generate(
 					panic(fmt.Sprintf("bad field %s with value type: %T with ", fieldName, v))
 				}
 
-				labels := []prompb.Label{
-					prompb.Label{Name: labels.MetricName, Value: string(p.MeasurementName)},
-					prompb.Label{Name: "measurement", Value: string(fieldName)},
-					prompb.Label{Name: string(devops.MachineTagKeys[0]), Value: string(host.Name)},
-					prompb.Label{Name: string(devops.MachineTagKeys[1]), Value: string(host.Region)},
-					prompb.Label{Name: string(devops.MachineTagKeys[2]), Value: string(host.Datacenter)},
-					prompb.Label{Name: string(devops.MachineTagKeys[3]), Value: string(host.Rack)},
-					prompb.Label{Name: string(devops.MachineTagKeys[4]), Value: string(host.OS)},
-					prompb.Label{Name: string(devops.MachineTagKeys[5]), Value: string(host.Arch)},
-					prompb.Label{Name: string(devops.MachineTagKeys[6]), Value: string(host.Team)},
-					prompb.Label{Name: string(devops.MachineTagKeys[7]), Value: string(host.Service)},
-					prompb.Label{Name: string(devops.MachineTagKeys[8]), Value: string(host.ServiceVersion)},
-					prompb.Label{Name: string(devops.MachineTagKeys[9]), Value: string(host.ServiceEnvironment)},
+				labels := h.seriesLabelsWithLock(host, p, fieldName)
+				labels, renamed := h.applyLabelRenamesWithLock(host, labelRenameStates, labels, now)
+				staleLabels := h.applyMetricRenamesWithLock(host, renameStates, labels, now)
+				if renamed || staleLabels != nil {
+					// Renamed for the first time, so a new series
+					if err := checkSeriesCreated(h.seriesCreated, 1, h.maxSeriesCreated); err != nil {
+						return err
+					}
+					h.seriesCreated++
+				}
+				if staleLabels != nil {
+					err := fn(host.key(), prompb.TimeSeries{
+						Labels: staleLabels,
+						Samples: []prompb.Sample{{
+							Value:     staleNaN,
+							Timestamp: nowUnixMilliseconds,
+						}},
+					})
+					if err != nil {
+						return err
+					}
+				}
+				fingerprint := seriesFingerprint(labels)
+
+				if hasValueRange {
+					val = valueRange.sample(h.rng)
 				}
+				if fingerprintFraction(fingerprint) < h.integerPercent {
+					val = math.Round(val)
+				}
+				val = h.heldValueWithLock(held, fingerprint, val)
+				if isCounter {
+					val = counterValue(counters, fingerprint, val)
+				}
+				if emit {
+					val = h.injectSpecialValueWithLock(val)
+				} else {
+					val = staleNaN
+				}
+
 				sample := prompb.Sample{
 					Value:     val,
 					Timestamp: nowUnixMilliseconds,
 				}
 
-				allSeries = append(allSeries, prompb.TimeSeries{
+				err := fn(host.key(), prompb.TimeSeries{
 					Labels:  labels,
 					Samples: []prompb.Sample{sample},
 				})
-
+				if err != nil {
+					return err
+				}
 			}
 		}
-		hostValues[string(host.Name)] = allSeries
+		err := h.histogramSeriesWithLock(host, debugActive,
+			nowUnixMilliseconds, budget-fieldIndex, false, fn)
+		if err != nil {
+			return err
+		}
+		commitMetricRenameStates(renameStates)
+		commitLabelRenameStates(labelRenameStates)
 	}
 
-	return hostValues, nil
+	return nil
 }
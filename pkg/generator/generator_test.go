@@ -0,0 +1,260 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const testScrapeInterval = 10 * time.Second
+
+var testStart = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestHosts(
+	t *testing.T,
+	hostCount int,
+	clock *VirtualClock,
+	opts HostsSimulatorOptions,
+) *HostsSimulator {
+	t.Helper()
+
+	opts.TimeNowFn = clock.Now
+	if opts.Seed == 0 {
+		opts.Seed = 1
+	}
+	sim, err := NewHostsSimulator(hostCount, testStart, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+// scrape advances the clock by a scrape interval and generates a full pass
+// over all targets.
+func scrape(
+	t *testing.T,
+	sim Simulator,
+	clock *VirtualClock,
+	newSeriesPercent float64,
+) []prompb.TimeSeries {
+	t.Helper()
+
+	clock.Set(clock.Now().Add(testScrapeInterval))
+	var result []prompb.TimeSeries
+	err := sim.GenerateStream(testScrapeInterval, testScrapeInterval, newSeriesPercent,
+		func(series prompb.TimeSeries) error {
+			result = append(result, series)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// seriesKey identifies a series by its sorted labels.
+func seriesKey(seriesLabels []prompb.Label) string {
+	sorted := append([]prompb.Label(nil), seriesLabels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return fmt.Sprint(sorted)
+}
+
+func labelValue(seriesLabels []prompb.Label, name string) (string, bool) {
+	for _, l := range seriesLabels {
+		if l.Name == name {
+			return l.Value, true
+		}
+	}
+	return "", false
+}
+
+func isStale(series prompb.TimeSeries) bool {
+	return len(series.Samples) > 0 && value.IsStaleNaN(series.Samples[0].Value)
+}
+
+// hostnames returns the number of series per hostname, skipping staleness
+// markers.
+func hostnames(series []prompb.TimeSeries) map[string]int {
+	result := make(map[string]int)
+	for _, s := range series {
+		if isStale(s) {
+			continue
+		}
+		hostname, _ := labelValue(s.Labels, "hostname")
+		result[hostname]++
+	}
+	return result
+}
+
+func newHostnames(before, after map[string]int) int {
+	n := 0
+	for hostname := range after {
+		if _, ok := before[hostname]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+func TestChurnReplacesHostsAfterEveryPass(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 10, clock, HostsSimulatorOptions{})
+	active := sim.ActiveSeries()
+
+	first := hostnames(scrape(t, sim, clock, 0.2))
+	if len(first) != 10 {
+		t.Fatalf("unexpected hosts of the first pass: hosts=%v", first)
+	}
+	second := hostnames(scrape(t, sim, clock, 0.2))
+	if n := newHostnames(first, second); n != 2 {
+		t.Errorf("unexpected hosts replaced by churn: replaced=%d, expected=2", n)
+	}
+	if len(second) != 10 || sim.ActiveSeries() != active {
+		t.Errorf("churn changed the active series: hosts=%d, active=%d, expected=%d",
+			len(second), sim.ActiveSeries(), active)
+	}
+
+	third := hostnames(scrape(t, sim, clock, 0))
+	if n := newHostnames(second, third); n != 0 {
+		t.Errorf("hosts replaced without churn: replaced=%d", n)
+	}
+}
+
+func TestConstantChurnCarriesRemainder(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 10, clock, HostsSimulatorOptions{ConstantChurn: true})
+	active := sim.ActiveSeries()
+
+	previous := hostnames(scrape(t, sim, clock, 0))
+	// 2.5 hosts are due per call, so 2 and then 3 are replaced
+	for i, expected := range []int{2, 3, 2, 3} {
+		current := hostnames(scrape(t, sim, clock, 0.25))
+		if n := newHostnames(previous, current); n != expected {
+			t.Errorf("unexpected hosts replaced: call=%d, replaced=%d, expected=%d",
+				i, n, expected)
+		}
+		if len(current) != 10 || sim.ActiveSeries() != active {
+			t.Errorf("constant churn changed the active series: call=%d, hosts=%d", i, len(current))
+		}
+		previous = current
+	}
+}
+
+func TestStaleMarkersOnChurn(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 4, clock, HostsSimulatorOptions{StaleMarkersOnChurn: true})
+
+	first := scrape(t, sim, clock, 0.25)
+	firstKeys := make(map[string]struct{})
+	for _, s := range first {
+		firstKeys[seriesKey(s.Labels)] = struct{}{}
+	}
+
+	second := scrape(t, sim, clock, 0.25)
+	now := clock.Now().UnixNano() / int64(time.Millisecond)
+	stale := make(map[string]int)
+	for _, s := range second {
+		if !isStale(s) {
+			continue
+		}
+		if _, ok := firstKeys[seriesKey(s.Labels)]; !ok {
+			t.Errorf("stale marker for a series never emitted: labels=%v", s.Labels)
+		}
+		if s.Samples[0].Timestamp != now {
+			t.Errorf("stale marker not at the scrape time: timestamp=%d, expected=%d",
+				s.Samples[0].Timestamp, now)
+		}
+		hostname, _ := labelValue(s.Labels, "hostname")
+		stale[hostname]++
+	}
+	retired := hostnames(first)
+	for hostname := range hostnames(second) {
+		delete(retired, hostname)
+	}
+	if len(retired) != 1 || len(stale) != 1 {
+		t.Fatalf("expected the series of one retired host marked stale: retired=%v, stale=%v",
+			retired, stale)
+	}
+	for hostname, n := range retired {
+		if stale[hostname] != n {
+			t.Errorf("not every series of the retired host marked stale: stale=%d, expected=%d",
+				stale[hostname], n)
+		}
+	}
+
+	for _, s := range scrape(t, sim, clock, 0) {
+		if isStale(s) {
+			t.Fatalf("stale markers emitted again: labels=%v", s.Labels)
+		}
+	}
+}
+
+func TestTargetActiveSeries(t *testing.T) {
+	for _, target := range []int{1, 250, 1010} {
+		clock := NewVirtualClock(testStart)
+		sim := newTestHosts(t, 1, clock, HostsSimulatorOptions{TargetActiveSeries: target})
+		if active := sim.ActiveSeries(); active != target {
+			t.Errorf("unexpected active series: active=%d, target=%d", active, target)
+		}
+
+		keys := make(map[string]struct{})
+		for _, s := range scrape(t, sim, clock, 0) {
+			keys[seriesKey(s.Labels)] = struct{}{}
+		}
+		if len(keys) != target {
+			t.Errorf("unexpected series scraped: series=%d, target=%d", len(keys), target)
+		}
+	}
+}
+
+func TestNewHostsSimulatorValidatesOptions(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	tests := []struct {
+		name string
+		opts HostsSimulatorOptions
+	}{
+		{name: "nan NaNPercent", opts: HostsSimulatorOptions{NaNPercent: nan}},
+		{name: "inf InfPercent", opts: HostsSimulatorOptions{InfPercent: inf}},
+		{name: "special values sum", opts: HostsSimulatorOptions{NaNPercent: 0.6, InfPercent: 0.6}},
+		{name: "nan IntegerSeriesPercent", opts: HostsSimulatorOptions{IntegerSeriesPercent: nan}},
+		{name: "inf ConstantSeriesPercent", opts: HostsSimulatorOptions{ConstantSeriesPercent: inf}},
+		{name: "negative SlowChangingSeriesPercent", opts: HostsSimulatorOptions{SlowChangingSeriesPercent: -0.1}},
+		{name: "held values sum", opts: HostsSimulatorOptions{
+			ConstantSeriesPercent: 0.5, SlowChangingSeriesPercent: 0.6}},
+		{name: "CounterResetPercent over one", opts: HostsSimulatorOptions{CounterResetPercent: 2}},
+		{name: "ClusterOverlapPercent over one", opts: HostsSimulatorOptions{
+			Clusters: 2, ClusterOverlapPercent: 1.5}},
+		{name: "nan ClusterOverlapPercent", opts: HostsSimulatorOptions{ClusterOverlapPercent: nan}},
+		{name: "Labels template", opts: HostsSimulatorOptions{
+			Labels: map[string]string{"shard": "{{.Missing}}"}}},
+		{name: "LabelDistributions pool", opts: HostsSimulatorOptions{
+			LabelDistributions: map[string]LabelDistribution{"zone": {Pool: 0}}}},
+		{name: "Growth", opts: HostsSimulatorOptions{Growth: Growth{Mode: GrowthLinear}}},
+		{name: "DuplicateSeries", opts: HostsSimulatorOptions{DuplicateSeries: "ignore"}},
+	}
+	for _, test := range tests {
+		sim, err := NewHostsSimulator(1, testStart, test.opts)
+		if err == nil || sim != nil {
+			t.Errorf("expected an invalid options error: name=%s", test.name)
+		}
+	}
+
+	if _, err := NewHostsSimulator(1, testStart, HostsSimulatorOptions{
+		NaNPercent:                0.5,
+		InfPercent:                0.5,
+		ConstantSeriesPercent:     0.5,
+		SlowChangingSeriesPercent: 0.5,
+		CounterResetPercent:       1,
+		Clusters:                  2,
+		ClusterOverlapPercent:     1,
+	}); err != nil {
+		t.Errorf("unexpected error for fractions at their bounds: err=%v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// GrowthLinear adds Factor times the initial hosts every Every.
+	GrowthLinear = "linear"
+	// GrowthExponential multiplies the hosts by Factor every Every.
+	GrowthExponential = "exponential"
+)
+
+// Growth grows the number of simulated hosts over a run until a cap, to
+// find the cardinality at which a backend breaks rather than only testing a
+// fixed one. Hosts are added in proportion to the time elapsed since the
+// simulator's start, as of each call to Generate.
+type Growth struct {
+	// Mode is GrowthLinear or GrowthExponential.
+	Mode   string
+	Factor float64
+	Every  time.Duration
+	// MaxHosts caps the hosts per cluster, or the targets of a farm, zero
+	// meaning no cap other than MaxSeriesCreated.
+	MaxHosts int
+}
+
+// ParseGrowth parses a growth written as
+// "<mode>:<factor>:<every>[:<max hosts>]", e.g. "linear:0.5:10m" or
+// "exponential:2:1h:100000".
+func ParseGrowth(s string) (Growth, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 3 || len(parts) > 4 {
+		return Growth{}, fmt.Errorf(
+			"growth must be <mode>:<factor>:<every>[:<max hosts>]: value=%s", s)
+	}
+	factor, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return Growth{}, fmt.Errorf("invalid growth factor: value=%s, err=%v", s, err)
+	}
+	every, err := time.ParseDuration(parts[2])
+	if err != nil {
+		return Growth{}, fmt.Errorf("invalid growth interval: value=%s, err=%v", s, err)
+	}
+	g := Growth{Mode: parts[0], Factor: factor, Every: every}
+	if len(parts) == 4 {
+		if g.MaxHosts, err = strconv.Atoi(parts[3]); err != nil {
+			return Growth{}, fmt.Errorf("invalid growth max hosts: value=%s, err=%v", s, err)
+		}
+	}
+	return g, g.Validate()
+}
+
+// Validate returns an error if the growth is set but invalid.
+func (g Growth) Validate() error {
+	switch g.Mode {
+	case "":
+		return nil
+	case GrowthLinear:
+		if g.Factor <= 0 {
+			return fmt.Errorf("linear growth factor must be positive: factor=%v", g.Factor)
+		}
+	case GrowthExponential:
+		if g.Factor <= 1 {
+			return fmt.Errorf("exponential growth factor must be greater than one: factor=%v", g.Factor)
+		}
+	default:
+		return fmt.Errorf("unknown growth mode: mode=%s, supported=%v",
+			g.Mode, []string{GrowthLinear, GrowthExponential})
+	}
+	if g.Every <= 0 {
+		return fmt.Errorf("growth interval must be positive: every=%v", g.Every)
+	}
+	return nil
+}
+
+// At returns the count grown to the given time into a run that started
+// with initial, e.g. the hosts per cluster of a simulator or the targets of
+// a farm.
+func (g Growth) At(initial int, elapsed time.Duration) int {
+	if g.Mode == "" || elapsed <= 0 {
+		return initial
+	}
+
+	steps := float64(elapsed) / float64(g.Every)
+	hosts := float64(initial)
+	switch g.Mode {
+	case GrowthLinear:
+		hosts += g.Factor * steps * float64(initial)
+	case GrowthExponential:
+		hosts *= math.Pow(g.Factor, steps)
+	}
+	if g.MaxHosts > 0 {
+		hosts = math.Min(hosts, float64(g.MaxHosts))
+	}
+	return int(math.Min(hosts, math.MaxInt32))
+}
+
+// growWithLock adds the hosts due by now to every cluster. New hosts are
+// appended to allHosts so they are scraped from the next pass.
+func (h *HostsSimulator) growWithLock(now time.Time) error {
+	if h.growth.Mode == "" {
+		return nil
+	}
+
+	perCluster := len(h.allHosts) / len(h.clusters)
+	add := h.growth.At(h.initialHosts, now.Sub(h.start)) - perCluster
+	if add <= 0 {
+		return nil
+	}
+	if err := checkSeriesCreated(
+		h.seriesCreated, add*len(h.clusters)*h.seriesPerHost, h.maxSeriesCreated,
+	); err != nil {
+		return err
+	}
+	h.seriesCreated += add * len(h.clusters) * h.seriesPerHost
+
+	// Keep the rest of the current pass a view of allHosts, which may be
+	// reallocated, so that it sees hosts replaced by churn
+	position := len(h.allHosts) - len(h.hosts)
+	end := len(h.allHosts)
+	for i := 0; i < add; i++ {
+		for _, cluster := range h.clusters {
+			newHostIndex := h.nextHostIndexWithLock()
+			h.allHosts = append(h.allHosts, newSimulatedHost(newHost(h.rng, newHostIndex, now), cluster,
+				h.labelTemplates, h.labelDistributions, newHostIndex, h.shardID))
+		}
+	}
+	h.hosts = h.allHosts[position:end]
+	return nil
+}
@@ -0,0 +1,246 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// HighFrequencySimulator is a control workload to contrast with the high
+// cardinality ones: few series, each sampled at a sub-second interval, so
+// that a backend's per-series append throughput is measured rather than its
+// index. Every call to Generate is a full pass over all series, each
+// carrying every sample due since the previous call.
+type HighFrequencySimulator struct {
+	sync.Mutex
+	series           []*highFrequencySeries
+	rng              *rand.Rand
+	interval         int64
+	seriesPerDevice  int
+	nextIndex        int
+	timeNowFn        func() time.Time
+	maxSeriesCreated int
+	seriesCreated    int
+}
+
+type HighFrequencySimulatorOptions struct {
+	TimeNowFn func() time.Time
+	// Seed when non-zero makes runs with the same options reproducible.
+	Seed int64
+	// MaxSeriesCreated when set is a safety cap on the cumulative number of
+	// series created, initially and by churn, once exceeded generating and
+	// churning return an error.
+	MaxSeriesCreated int
+	// SampleInterval is the interval between samples of each series, at
+	// least a millisecond.
+	SampleInterval time.Duration
+	// SeriesPerDevice is the number of series sharing a device label, the
+	// key of the SeriesBatch returned by Generate.
+	SeriesPerDevice int
+}
+
+const (
+	defaultHighFrequencySampleInterval  = 100 * time.Millisecond
+	defaultHighFrequencySeriesPerDevice = 16
+)
+
+type highFrequencySeries struct {
+	labels []prompb.Label
+	device string
+	// last is the timestamp of the last sample, zero before the first.
+	last  int64
+	value float64
+}
+
+func NewHighFrequencySimulator(
+	seriesCount int,
+	opts HighFrequencySimulatorOptions,
+) *HighFrequencySimulator {
+	interval := defaultHighFrequencySampleInterval
+	if opts.SampleInterval > 0 {
+		interval = opts.SampleInterval
+	}
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	seriesPerDevice := defaultHighFrequencySeriesPerDevice
+	if opts.SeriesPerDevice > 0 {
+		seriesPerDevice = opts.SeriesPerDevice
+	}
+
+	timeNowFn := time.Now
+	if opts.TimeNowFn != nil {
+		timeNowFn = opts.TimeNowFn
+	}
+
+	seed := time.Now().UnixNano()
+	if opts.Seed != 0 {
+		seed = opts.Seed
+	}
+
+	s := &HighFrequencySimulator{
+		rng:              rand.New(rand.NewSource(seed)),
+		interval:         int64(interval / time.Millisecond),
+		seriesPerDevice:  seriesPerDevice,
+		timeNowFn:        timeNowFn,
+		maxSeriesCreated: opts.MaxSeriesCreated,
+		seriesCreated:    seriesCount,
+	}
+	for i := 0; i < seriesCount; i++ {
+		s.series = append(s.series, s.newSeriesWithLock())
+	}
+	return s
+}
+
+func (s *HighFrequencySimulator) newSeriesWithLock() *highFrequencySeries {
+	index := s.nextIndex
+	s.nextIndex++
+	device := "device_" + strconv.Itoa(index/s.seriesPerDevice)
+	return &highFrequencySeries{
+		labels: []prompb.Label{
+			{Name: labels.MetricName, Value: "sensor_value"},
+			{Name: "device", Value: device},
+			{Name: "sensor", Value: "sensor_" + strconv.Itoa(index)},
+		},
+		device: device,
+		value:  100 * s.rng.Float64(),
+	}
+}
+
+// Churn immediately replaces the given fraction [0.0,1.0] of series with
+// new ones.
+func (s *HighFrequencySimulator) Churn(newSeriesPercent float64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if newSeriesPercent < 0 || newSeriesPercent > 1 {
+		return fmt.Errorf(
+			"newSeriesPercent not between [0.0,1.0]: value=%v",
+			newSeriesPercent)
+	}
+
+	return s.churnWithLock(newSeriesPercent)
+}
+
+func (s *HighFrequencySimulator) churnWithLock(newSeriesPercent float64) error {
+	if newSeriesPercent <= 0 {
+		return nil
+	}
+
+	replace := int(math.Ceil(newSeriesPercent * float64(len(s.series))))
+	if err := checkSeriesCreated(s.seriesCreated, replace, s.maxSeriesCreated); err != nil {
+		return err
+	}
+	s.seriesCreated += replace
+	for _, i := range s.rng.Perm(len(s.series))[:replace] {
+		s.series[i] = s.newSeriesWithLock()
+	}
+	return nil
+}
+
+func (s *HighFrequencySimulator) ActiveSeries() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.series)
+}
+
+func (s *HighFrequencySimulator) Generate(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+) (SeriesBatch, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	deviceValues := make(SeriesBatch)
+	err := s.generateWithLock(progressBy, newSeriesPercent,
+		func(key string, series prompb.TimeSeries) error {
+			deviceValues[key] = append(deviceValues[key], series)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return deviceValues, nil
+}
+
+func (s *HighFrequencySimulator) GenerateStream(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+	fn func(series prompb.TimeSeries) error,
+) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.generateWithLock(progressBy, newSeriesPercent,
+		func(_ string, series prompb.TimeSeries) error {
+			return fn(series)
+		})
+}
+
+// generateWithLock emits, for every series, the samples due since its last
+// sample and at most progressBy ago, then churns the given fraction of
+// series as every call is a full pass.
+func (s *HighFrequencySimulator) generateWithLock(
+	progressBy time.Duration,
+	newSeriesPercent float64,
+	fn func(key string, series prompb.TimeSeries) error,
+) error {
+	if newSeriesPercent < 0 || newSeriesPercent > 1 {
+		return fmt.Errorf(
+			"newSeriesPercent not between [0.0,1.0]: value=%v",
+			newSeriesPercent)
+	}
+
+	if err := checkSeriesCreated(s.seriesCreated, 0, s.maxSeriesCreated); err != nil {
+		return err
+	}
+
+	now := s.timeNowFn().UnixNano() / int64(time.Millisecond)
+	from := now - int64(progressBy/time.Millisecond)
+	for _, series := range s.series {
+		start := from
+		if series.last > start {
+			start = series.last
+		}
+		// Samples are aligned to the interval so that every series is
+		// sampled at the same instants
+		first := (start/s.interval + 1) * s.interval
+		if first > now {
+			continue
+		}
+
+		samples := make([]prompb.Sample, 0, (now-first)/s.interval+1)
+		for t := first; t <= now; t += s.interval {
+			series.value += s.rng.NormFloat64()
+			samples = append(samples, prompb.Sample{Value: series.value, Timestamp: t})
+			series.last = t
+		}
+		err := fn(series.device, prompb.TimeSeries{
+			Labels:  append([]prompb.Label(nil), series.labels...),
+			Samples: samples,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.churnWithLock(newSeriesPercent)
+}
+
+func (s *HighFrequencySimulator) MetricMetadata(name string) MetricMetadata {
+	if name != "sensor_value" {
+		return MetricMetadata{Family: name, Type: MetricTypeUnknown}
+	}
+	return MetricMetadata{
+		Family: name,
+		Type:   MetricTypeGauge,
+		Help:   "Simulated sensor reading sampled at a sub-second interval.",
+	}
+}
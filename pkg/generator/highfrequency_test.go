@@ -0,0 +1,102 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestHighFrequencySamplesDueSinceLastCall(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := NewHighFrequencySimulator(20, HighFrequencySimulatorOptions{
+		TimeNowFn:       clock.Now,
+		Seed:            1,
+		SampleInterval:  100 * time.Millisecond,
+		SeriesPerDevice: 8,
+	})
+	if active := sim.ActiveSeries(); active != 20 {
+		t.Fatalf("unexpected active series: active=%d", active)
+	}
+
+	batch, err := sim.Generate(time.Second, time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := make(map[string]int64)
+	series := 0
+	for device, deviceSeries := range batch {
+		if len(deviceSeries) > 8 {
+			t.Errorf("too many series for a device: device=%s, series=%d", device, len(deviceSeries))
+		}
+		for _, s := range deviceSeries {
+			series++
+			checkHighFrequencySamples(t, s, 10, 100)
+			last[seriesKey(s.Labels)] = s.Samples[len(s.Samples)-1].Timestamp
+		}
+	}
+	if series != 20 || len(batch) != 3 {
+		t.Fatalf("unexpected series per pass: series=%d, devices=%d", series, len(batch))
+	}
+
+	// Only samples after the last are emitted, however far back progressBy
+	clock.Set(testStart.Add(500 * time.Millisecond))
+	err = sim.GenerateStream(time.Minute, time.Minute, 0, func(s prompb.TimeSeries) error {
+		checkHighFrequencySamples(t, s, 5, 100)
+		if s.Samples[0].Timestamp != last[seriesKey(s.Labels)]+100 {
+			t.Errorf("samples do not continue from the last: first=%d, last=%d",
+				s.Samples[0].Timestamp, last[seriesKey(s.Labels)])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkHighFrequencySamples(t *testing.T, s prompb.TimeSeries, n int, interval int64) {
+	t.Helper()
+
+	if len(s.Samples) != n {
+		t.Fatalf("unexpected samples: samples=%d, expected=%d", len(s.Samples), n)
+	}
+	for i := 1; i < len(s.Samples); i++ {
+		if d := s.Samples[i].Timestamp - s.Samples[i-1].Timestamp; d != interval {
+			t.Errorf("samples not at the interval: delta=%d, expected=%d", d, interval)
+		}
+	}
+}
+
+func TestHighFrequencyChurn(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := NewHighFrequencySimulator(10, HighFrequencySimulatorOptions{
+		TimeNowFn:        clock.Now,
+		Seed:             1,
+		MaxSeriesCreated: 13,
+	})
+
+	before := make(map[string]struct{})
+	if err := sim.GenerateStream(time.Second, time.Second, 0.3, func(s prompb.TimeSeries) error {
+		before[seriesKey(s.Labels)] = struct{}{}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Set(testStart.Add(time.Second))
+	replaced := 0
+	if err := sim.GenerateStream(time.Second, time.Second, 0, func(s prompb.TimeSeries) error {
+		if _, ok := before[seriesKey(s.Labels)]; !ok {
+			replaced++
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if replaced != 3 || sim.ActiveSeries() != 10 {
+		t.Errorf("unexpected churn: replaced=%d, active=%d", replaced, sim.ActiveSeries())
+	}
+
+	if err := sim.Churn(0.1); err == nil {
+		t.Error("expected max series created error")
+	}
+}
@@ -0,0 +1,238 @@
+package generator
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/influxdata/influxdb-comparisons/bulk_data_gen/devops"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// HistogramFamily is a classic histogram, or a summary, emitted by every
+// host in addition to its measurements.
+type HistogramFamily struct {
+	// Name is the metric name the _bucket, _sum and _count suffixes (or the
+	// quantiles, _sum and _count of a summary) are emitted under.
+	Name string
+	// Summary emits the family as a summary with quantile labels instead
+	// of cumulative buckets.
+	Summary bool
+	// Buckets is the number of buckets excluding +Inf, exponentially
+	// spaced from BucketStart by BucketFactor.
+	Buckets      int
+	BucketStart  float64
+	BucketFactor float64
+	// Quantiles are the summary quantiles.
+	Quantiles []float64
+	// Series is the number of label sets each host emits the family with,
+	// distinguished by a "handler" label.
+	Series int
+	// ObservationsPerScrape is the mean number of observations made per
+	// series between scrapes.
+	ObservationsPerScrape int
+}
+
+const (
+	defaultHistogramBuckets      = 10
+	defaultHistogramBucketStart  = 0.001
+	defaultHistogramBucketFactor = 2
+	defaultHistogramSeries       = 1
+	defaultHistogramObservations = 10
+)
+
+var defaultSummaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+func (f HistogramFamily) withDefaults() HistogramFamily {
+	if f.Buckets <= 0 {
+		f.Buckets = defaultHistogramBuckets
+	}
+	if f.BucketStart <= 0 {
+		f.BucketStart = defaultHistogramBucketStart
+	}
+	if f.BucketFactor <= 1 {
+		f.BucketFactor = defaultHistogramBucketFactor
+	}
+	if len(f.Quantiles) == 0 {
+		f.Quantiles = defaultSummaryQuantiles
+	}
+	if f.Series <= 0 {
+		f.Series = defaultHistogramSeries
+	}
+	if f.ObservationsPerScrape <= 0 {
+		f.ObservationsPerScrape = defaultHistogramObservations
+	}
+	return f
+}
+
+func (f HistogramFamily) upperBounds() []float64 {
+	bounds := make([]float64, 0, f.Buckets)
+	bound := f.BucketStart
+	for i := 0; i < f.Buckets; i++ {
+		bounds = append(bounds, bound)
+		bound *= f.BucketFactor
+	}
+	return bounds
+}
+
+// seriesPerLabelSet is the number of series emitted per label set, i.e.
+// each bucket and +Inf, or each quantile, plus _sum and _count.
+func (f HistogramFamily) seriesPerLabelSet() int {
+	if f.Summary {
+		return len(f.Quantiles) + 2
+	}
+	return f.Buckets + 3
+}
+
+type histogramState struct {
+	buckets []float64
+	sum     float64
+	count   float64
+}
+
+// histogramStatesWithLock returns the host's state for each label set of
+// each family, in the order of h.histograms.
+func (h *HostsSimulator) histogramStatesWithLock(host simulatedHost) [][]*histogramState {
+	states := h.histogramStates[host.key()]
+	if states != nil {
+		return states
+	}
+	states = make([][]*histogramState, 0, len(h.histograms))
+	for _, family := range h.histograms {
+		familyStates := make([]*histogramState, 0, family.Series)
+		for i := 0; i < family.Series; i++ {
+			familyStates = append(familyStates, &histogramState{
+				buckets: make([]float64, family.Buckets),
+			})
+		}
+		states = append(states, familyStates)
+	}
+	h.histogramStates[host.key()] = states
+	return states
+}
+
+// observeWithLock records a scrape interval's worth of observations drawn
+// from a log-normal distribution centered on the middle bucket, returning
+// the observations sorted.
+func (h *HostsSimulator) observeWithLock(
+	family HistogramFamily,
+	state *histogramState,
+	bounds []float64,
+) []float64 {
+	n := h.rng.Intn(2*family.ObservationsPerScrape + 1)
+	mu := math.Log(bounds[len(bounds)/2])
+	sigma := math.Log(family.BucketFactor) * float64(family.Buckets) / 6
+	observations := make([]float64, 0, n)
+	for i := 0; i < n; i++ {
+		observations = append(observations, math.Exp(mu+sigma*h.rng.NormFloat64()))
+	}
+	sort.Float64s(observations)
+
+	for _, v := range observations {
+		for i, bound := range bounds {
+			if v <= bound {
+				state.buckets[i]++
+			}
+		}
+		state.sum += v
+		state.count++
+	}
+	return observations
+}
+
+// histogramSeriesWithLock emits the host's histogram and summary series,
+// at most budget of them. When stale every value is a staleness marker and
+// nothing is observed.
+func (h *HostsSimulator) histogramSeriesWithLock(
+	host simulatedHost,
+	debugActive bool,
+	timestamp int64,
+	budget int,
+	stale bool,
+	fn func(key string, series prompb.TimeSeries) error,
+) error {
+	staleNaN := math.Float64frombits(value.StaleNaN)
+	states := h.histogramStatesWithLock(host)
+	for i, family := range h.histograms {
+		if !h.emitMetricFamilyWithLock(family.Name, debugActive) {
+			budget -= family.Series * family.seriesPerLabelSet()
+			continue
+		}
+
+		bounds := family.upperBounds()
+		for j, state := range states[i] {
+			var observations []float64
+			if !stale {
+				observations = h.observeWithLock(family, state, bounds)
+			}
+			handler := "handler_" + strconv.Itoa(j)
+
+			var series []prompb.TimeSeries
+			add := func(name string, v float64, extra ...prompb.Label) {
+				if stale {
+					v = staleNaN
+				}
+				seriesLabels := make([]prompb.Label, 0, 4+len(extra))
+				seriesLabels = append(seriesLabels,
+					prompb.Label{Name: labels.MetricName, Value: name},
+					prompb.Label{Name: "handler", Value: handler},
+					prompb.Label{Name: string(devops.MachineTagKeys[0]), Value: string(host.Name)},
+				)
+				seriesLabels = append(seriesLabels, extra...)
+				if host.cluster != "" {
+					seriesLabels = append(seriesLabels, prompb.Label{Name: h.clusterLabel, Value: host.cluster})
+				}
+				series = append(series, prompb.TimeSeries{
+					Labels:  setLabels(seriesLabels, host.labels),
+					Samples: []prompb.Sample{{Value: v, Timestamp: timestamp}},
+				})
+			}
+
+			if family.Summary {
+				for _, q := range family.Quantiles {
+					add(family.Name, quantile(observations, q),
+						prompb.Label{Name: "quantile", Value: formatBound(q)})
+				}
+			} else {
+				for k, bound := range bounds {
+					add(family.Name+"_bucket", state.buckets[k],
+						prompb.Label{Name: "le", Value: formatBound(bound)})
+				}
+				add(family.Name+"_bucket", state.count,
+					prompb.Label{Name: "le", Value: "+Inf"})
+			}
+			add(family.Name+"_sum", state.sum)
+			add(family.Name+"_count", state.count)
+
+			for _, s := range series {
+				if budget <= 0 {
+					return nil
+				}
+				budget--
+				if err := fn(host.key(), s); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// quantile returns the q-quantile of the sorted observations, or NaN if
+// there are none as Prometheus client summaries do.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func formatBound(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
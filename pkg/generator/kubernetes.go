@@ -0,0 +1,395 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// KubernetesSimulator models the cardinality of a Kubernetes cluster as
+// scraped from cAdvisor and kube-state-metrics: namespaces, deployments,
+// replicasets, pods and containers. Rolling out a deployment replaces its
+// replicaset and every one of its pods, and with them all their series.
+type KubernetesSimulator struct {
+	sync.RWMutex
+	deployments      []*simulatedDeployment
+	pods             []*simulatedPod
+	allPods          []*simulatedPod
+	rng              *rand.Rand
+	containers       int
+	nodes            int
+	timeNowFn        func() time.Time
+	maxSeriesCreated int
+	seriesCreated    int
+}
+
+type KubernetesSimulatorOptions struct {
+	TimeNowFn func() time.Time
+	// Seed when non-zero makes runs with the same options reproducible.
+	Seed int64
+	// MaxSeriesCreated when set is a safety cap on the cumulative number of
+	// series created, initially and by rollouts, once exceeded generating
+	// and churning return an error.
+	MaxSeriesCreated int
+	// Namespaces is the number of namespaces deployments are spread across.
+	Namespaces int
+	// ReplicasPerDeployment is the number of pods in each deployment.
+	ReplicasPerDeployment int
+	// ContainersPerPod is the number of containers in each pod.
+	ContainersPerPod int
+	// Nodes is the number of nodes pods are scheduled on, defaults to one
+	// node per defaultPodsPerNode pods.
+	Nodes int
+}
+
+const (
+	defaultNamespaces            = 10
+	defaultReplicasPerDeployment = 3
+	defaultContainersPerPod      = 2
+	defaultPodsPerNode           = 30
+
+	// podNameAlphabet is the alphabet Kubernetes generates name suffixes
+	// from, it avoids vowels and confusable characters.
+	podNameAlphabet = "bcdfghjklmnpqrstvwxz2456789"
+)
+
+type simulatedDeployment struct {
+	namespace  string
+	name       string
+	replicaSet string
+	pods       []*simulatedPod
+}
+
+type simulatedPod struct {
+	deployment *simulatedDeployment
+	name       string
+	node       string
+	containers []simulatedContainer
+	restarts   float64
+	rxBytes    float64
+	txBytes    float64
+}
+
+type simulatedContainer struct {
+	name        string
+	cpuSeconds  float64
+	memoryBytes float64
+}
+
+// key uniquely identifies the pod across namespaces.
+func (p *simulatedPod) key() string {
+	return p.deployment.namespace + "/" + p.name
+}
+
+func NewKubernetesSimulator(
+	podCount int,
+	start time.Time,
+	opts KubernetesSimulatorOptions,
+) *KubernetesSimulator {
+	namespaces := defaultNamespaces
+	if opts.Namespaces > 0 {
+		namespaces = opts.Namespaces
+	}
+	replicas := defaultReplicasPerDeployment
+	if opts.ReplicasPerDeployment > 0 {
+		replicas = opts.ReplicasPerDeployment
+	}
+	containers := defaultContainersPerPod
+	if opts.ContainersPerPod > 0 {
+		containers = opts.ContainersPerPod
+	}
+	nodes := podCount/defaultPodsPerNode + 1
+	if opts.Nodes > 0 {
+		nodes = opts.Nodes
+	}
+
+	timeNowFn := time.Now
+	if opts.TimeNowFn != nil {
+		timeNowFn = opts.TimeNowFn
+	}
+
+	seed := time.Now().UnixNano()
+	if opts.Seed != 0 {
+		seed = opts.Seed
+	}
+
+	s := &KubernetesSimulator{
+		rng:              rand.New(rand.NewSource(seed)),
+		containers:       containers,
+		nodes:            nodes,
+		timeNowFn:        timeNowFn,
+		maxSeriesCreated: opts.MaxSeriesCreated,
+	}
+
+	deploymentCount := int(math.Ceil(float64(podCount) / float64(replicas)))
+	for i := 0; i < deploymentCount; i++ {
+		deployment := &simulatedDeployment{
+			namespace: fmt.Sprintf("namespace-%d", i%namespaces),
+			name:      fmt.Sprintf("deployment-%d", i),
+		}
+		s.rolloutWithLock(deployment, replicas)
+		s.deployments = append(s.deployments, deployment)
+		s.allPods = append(s.allPods, deployment.pods...)
+	}
+	s.pods = s.allPods
+	s.seriesCreated = len(s.allPods) * s.seriesPerPod()
+
+	return s
+}
+
+func (s *KubernetesSimulator) randomNameWithLock(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = podNameAlphabet[s.rng.Intn(len(podNameAlphabet))]
+	}
+	return string(b)
+}
+
+// rolloutWithLock replaces the deployment's replicaset and pods with new
+// ones, named the way the deployment and replicaset controllers name them.
+func (s *KubernetesSimulator) rolloutWithLock(
+	deployment *simulatedDeployment,
+	replicas int,
+) {
+	deployment.replicaSet = deployment.name + "-" + s.randomNameWithLock(10)
+	deployment.pods = make([]*simulatedPod, 0, replicas)
+	for i := 0; i < replicas; i++ {
+		pod := &simulatedPod{
+			deployment: deployment,
+			name:       deployment.replicaSet + "-" + s.randomNameWithLock(5),
+			node:       fmt.Sprintf("node-%d", s.rng.Intn(s.nodes)),
+			containers: make([]simulatedContainer, 0, s.containers),
+		}
+		for j := 0; j < s.containers; j++ {
+			pod.containers = append(pod.containers, simulatedContainer{
+				name:        fmt.Sprintf("container-%d", j),
+				memoryBytes: float64(64<<20) * (1 + s.rng.Float64()),
+			})
+		}
+		deployment.pods = append(deployment.pods, pod)
+	}
+}
+
+// Churn immediately rolls out the given fraction [0.0,1.0] of deployments,
+// replacing all of their pods.
+func (s *KubernetesSimulator) Churn(newSeriesPercent float64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if newSeriesPercent < 0 || newSeriesPercent > 1 {
+		return fmt.Errorf(
+			"newSeriesPercent not between [0.0,1.0]: value=%v",
+			newSeriesPercent)
+	}
+
+	return s.churnWithLock(newSeriesPercent)
+}
+
+func (s *KubernetesSimulator) churnWithLock(newSeriesPercent float64) error {
+	if newSeriesPercent <= 0 {
+		return nil
+	}
+
+	rollouts := int(math.Ceil(newSeriesPercent * float64(len(s.deployments))))
+	rolledOut := s.rng.Perm(len(s.deployments))[:rollouts]
+	adding := 0
+	for _, i := range rolledOut {
+		adding += len(s.deployments[i].pods) * s.seriesPerPod()
+	}
+	if err := checkSeriesCreated(s.seriesCreated, adding, s.maxSeriesCreated); err != nil {
+		return err
+	}
+	s.seriesCreated += adding
+
+	for _, i := range rolledOut {
+		deployment := s.deployments[i]
+		s.rolloutWithLock(deployment, len(deployment.pods))
+	}
+
+	// Pods are replaced in place, deployments keep their number of pods,
+	// so that the remainder of the current pass sees the new pods
+	s.allPods = s.allPods[:0]
+	for _, deployment := range s.deployments {
+		s.allPods = append(s.allPods, deployment.pods...)
+	}
+	return nil
+}
+
+// seriesPerPod is the number of series podSeriesWithLock returns.
+func (s *KubernetesSimulator) seriesPerPod() int {
+	return 4 + 3*s.containers
+}
+
+// ActiveSeries returns the number of series currently emitted per pass over
+// all pods.
+func (s *KubernetesSimulator) ActiveSeries() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.seriesPerPod() * len(s.allPods)
+}
+
+func (s *KubernetesSimulator) tickWithLock(elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	for _, pod := range s.allPods {
+		pod.rxBytes += seconds * 1e5 * s.rng.Float64()
+		pod.txBytes += seconds * 1e5 * s.rng.Float64()
+		if s.rng.Float64() < 0.001 {
+			pod.restarts++
+		}
+		for i := range pod.containers {
+			container := &pod.containers[i]
+			container.cpuSeconds += seconds * s.rng.Float64()
+			container.memoryBytes *= 0.95 + 0.1*s.rng.Float64()
+		}
+	}
+}
+
+func (s *KubernetesSimulator) podSeriesWithLock(
+	pod *simulatedPod,
+	timestamp int64,
+) []prompb.TimeSeries {
+	deployment := pod.deployment
+	series := make([]prompb.TimeSeries, 0, s.seriesPerPod())
+	add := func(value float64, seriesLabels ...prompb.Label) {
+		series = append(series, prompb.TimeSeries{
+			Labels:  seriesLabels,
+			Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+		})
+	}
+
+	add(1,
+		prompb.Label{Name: labels.MetricName, Value: "kube_pod_info"},
+		prompb.Label{Name: "namespace", Value: deployment.namespace},
+		prompb.Label{Name: "pod", Value: pod.name},
+		prompb.Label{Name: "node", Value: pod.node},
+		prompb.Label{Name: "created_by_kind", Value: "ReplicaSet"},
+		prompb.Label{Name: "created_by_name", Value: deployment.replicaSet})
+	add(1,
+		prompb.Label{Name: labels.MetricName, Value: "kube_pod_owner"},
+		prompb.Label{Name: "namespace", Value: deployment.namespace},
+		prompb.Label{Name: "pod", Value: pod.name},
+		prompb.Label{Name: "owner_kind", Value: "ReplicaSet"},
+		prompb.Label{Name: "owner_name", Value: deployment.replicaSet})
+	add(pod.rxBytes,
+		prompb.Label{Name: labels.MetricName, Value: "container_network_receive_bytes_total"},
+		prompb.Label{Name: "namespace", Value: deployment.namespace},
+		prompb.Label{Name: "pod", Value: pod.name},
+		prompb.Label{Name: "interface", Value: "eth0"})
+	add(pod.txBytes,
+		prompb.Label{Name: labels.MetricName, Value: "container_network_transmit_bytes_total"},
+		prompb.Label{Name: "namespace", Value: deployment.namespace},
+		prompb.Label{Name: "pod", Value: pod.name},
+		prompb.Label{Name: "interface", Value: "eth0"})
+
+	for _, container := range pod.containers {
+		add(container.cpuSeconds,
+			prompb.Label{Name: labels.MetricName, Value: "container_cpu_usage_seconds_total"},
+			prompb.Label{Name: "namespace", Value: deployment.namespace},
+			prompb.Label{Name: "pod", Value: pod.name},
+			prompb.Label{Name: "container", Value: container.name},
+			prompb.Label{Name: "node", Value: pod.node})
+		add(container.memoryBytes,
+			prompb.Label{Name: labels.MetricName, Value: "container_memory_working_set_bytes"},
+			prompb.Label{Name: "namespace", Value: deployment.namespace},
+			prompb.Label{Name: "pod", Value: pod.name},
+			prompb.Label{Name: "container", Value: container.name},
+			prompb.Label{Name: "node", Value: pod.node})
+		add(pod.restarts,
+			prompb.Label{Name: labels.MetricName, Value: "kube_pod_container_status_restarts_total"},
+			prompb.Label{Name: "namespace", Value: deployment.namespace},
+			prompb.Label{Name: "pod", Value: pod.name},
+			prompb.Label{Name: "container", Value: container.name})
+	}
+	return series
+}
+
+func (s *KubernetesSimulator) Generate(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+) (SeriesBatch, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	podValues := make(SeriesBatch)
+	err := s.generateWithLock(progressBy, scrapeDuration, newSeriesPercent,
+		func(key string, series prompb.TimeSeries) error {
+			podValues[key] = append(podValues[key], series)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return podValues, nil
+}
+
+func (s *KubernetesSimulator) GenerateStream(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+	fn func(series prompb.TimeSeries) error,
+) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.generateWithLock(progressBy, scrapeDuration, newSeriesPercent,
+		func(_ string, series prompb.TimeSeries) error {
+			return fn(series)
+		})
+}
+
+func (s *KubernetesSimulator) generateWithLock(
+	progressBy, scrapeDuration time.Duration,
+	newSeriesPercent float64,
+	fn func(key string, series prompb.TimeSeries) error,
+) error {
+	if newSeriesPercent < 0 || newSeriesPercent > 1 {
+		return fmt.Errorf(
+			"newSeriesPercent not between [0.0,1.0]: value=%v",
+			newSeriesPercent)
+	}
+
+	if err := checkSeriesCreated(s.seriesCreated, 0, s.maxSeriesCreated); err != nil {
+		return err
+	}
+
+	now := s.timeNowFn()
+	factorProgress := float64(progressBy) / float64(scrapeDuration)
+	numPods := int(math.Ceil(factorProgress * float64(len(s.allPods))))
+	if numPods == 0 {
+		// Always progress by at least one
+		numPods = 1
+	}
+	if len(s.pods) == 0 {
+		// Out of pods, roll out deployments as needed and progress ticking
+		s.tickWithLock(progressBy)
+		if err := s.churnWithLock(newSeriesPercent); err != nil {
+			return err
+		}
+		// Reset pods
+		s.pods = s.allPods
+	}
+	if len(s.pods) < numPods {
+		numPods = len(s.pods)
+	}
+
+	// Select pods
+	sendFromPods := s.pods[:numPods]
+
+	// Progress pods
+	s.pods = s.pods[numPods:]
+
+	nowUnixMilliseconds := now.UnixNano() / int64(time.Millisecond)
+	for _, pod := range sendFromPods {
+		for _, series := range s.podSeriesWithLock(pod, nowUnixMilliseconds) {
+			if err := fn(pod.key(), series); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
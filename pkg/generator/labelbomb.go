@@ -0,0 +1,98 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// LabelBomb models the classic accidental cardinality incident of a label
+// taking a fresh unique value on every scrape, like a request ID or pod
+// UID, so that every scrape of a host creates new series.
+type LabelBomb struct {
+	// Label is set on the series of every scrape of a host to a random
+	// value unique to that scrape.
+	Label string
+	// Metrics when set confines the bomb to series with these metric names,
+	// a histogram or summary family name matching all of its series.
+	Metrics []string
+}
+
+// labelBombFnWithLock wraps fn to set the label bomb on the series passed
+// to it. Labels are set after the series' values were computed, so that
+// per-series state is kept for the series without the bomb, and staleness
+// markers are passed through unchanged as the bombed series never repeat.
+// Every bombed series is a new series, counted towards MaxSeriesCreated.
+func (h *HostsSimulator) labelBombFnWithLock(
+	fn func(key string, series prompb.TimeSeries) error,
+) func(key string, series prompb.TimeSeries) error {
+	if h.labelBomb.Label == "" {
+		return fn
+	}
+
+	values := make(map[string]string)
+	return func(key string, series prompb.TimeSeries) error {
+		if len(series.Samples) > 0 && value.IsStaleNaN(series.Samples[0].Value) {
+			return fn(key, series)
+		}
+		if !h.labelBombMetric(series.Labels) {
+			return fn(key, series)
+		}
+		v, ok := values[key]
+		if !ok {
+			v = fmt.Sprintf("%016x", h.rng.Uint64())
+			values[key] = v
+		}
+		if err := checkSeriesCreated(h.seriesCreated, 1, h.maxSeriesCreated); err != nil {
+			return err
+		}
+		h.seriesCreated++
+		seriesLabels := append([]prompb.Label(nil), series.Labels...)
+		series.Labels = setLabels(seriesLabels, []prompb.Label{{
+			Name:  h.labelBomb.Label,
+			Value: v,
+		}})
+		return fn(key, series)
+	}
+}
+
+func (h *HostsSimulator) labelBombMetric(seriesLabels []prompb.Label) bool {
+	if len(h.labelBomb.Metrics) == 0 {
+		return true
+	}
+	i := metricNameIndex(seriesLabels)
+	if i < 0 {
+		return false
+	}
+	name := seriesLabels[i].Value
+	for _, metric := range h.labelBomb.Metrics {
+		if name == metric {
+			return true
+		}
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if name == metric+suffix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseLabelBomb parses the label_bomb and label_bomb_metrics simulator
+// params, the latter a comma separated list of metric names.
+func parseLabelBomb(params map[string]string) (LabelBomb, error) {
+	bomb := LabelBomb{Label: params["label_bomb"]}
+	if str, ok := params["label_bomb_metrics"]; ok {
+		if bomb.Label == "" {
+			return LabelBomb{}, fmt.Errorf("label bomb metrics without a label: value=%s", str)
+		}
+		for _, metric := range strings.Split(str, ",") {
+			if metric = strings.TrimSpace(metric); metric != "" {
+				bomb.Metrics = append(bomb.Metrics, metric)
+			}
+		}
+	}
+	return bomb, nil
+}
@@ -0,0 +1,83 @@
+package generator
+
+import (
+	"testing"
+)
+
+func TestLabelBombCountsSeriesCreated(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 1, clock, HostsSimulatorOptions{
+		LabelBomb: LabelBomb{Label: "request_id"},
+	})
+	active := sim.ActiveSeries()
+	sim.maxSeriesCreated = 3 * active
+
+	// The initial series and two scrapes of bombed series fit
+	for i := 0; i < 2; i++ {
+		scrape(t, sim, clock, 0)
+	}
+	if sim.seriesCreated != 3*active {
+		t.Errorf("bombed series not counted: created=%d, expected=%d",
+			sim.seriesCreated, 3*active)
+	}
+	if _, err := sim.Generate(testScrapeInterval, testScrapeInterval, 0); err == nil {
+		t.Error("expected max series created error")
+	}
+}
+
+func TestLabelBombValuePerHostAndScrape(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 3, clock, HostsSimulatorOptions{
+		LabelBomb: LabelBomb{Label: "request_id"},
+	})
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 3; i++ {
+		values := make(map[string]string)
+		for _, s := range scrape(t, sim, clock, 0) {
+			v, ok := labelValue(s.Labels, "request_id")
+			if !ok {
+				t.Fatalf("series without the label bomb: labels=%v", s.Labels)
+			}
+			hostname, _ := labelValue(s.Labels, "hostname")
+			if prev, ok := values[hostname]; ok && prev != v {
+				t.Errorf("label bomb values differ within a host's scrape: values=%s,%s", prev, v)
+			}
+			values[hostname] = v
+		}
+		for _, v := range values {
+			if _, ok := seen[v]; ok {
+				t.Errorf("label bomb value repeated: value=%s", v)
+			}
+			seen[v] = struct{}{}
+		}
+	}
+	if len(seen) != 9 {
+		t.Errorf("expected a value per host and scrape: values=%d", len(seen))
+	}
+}
+
+func TestLabelBombMetrics(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 1, clock, HostsSimulatorOptions{
+		LabelBomb: LabelBomb{Label: "request_id", Metrics: []string{"mem"}},
+	})
+
+	bombed := 0
+	for _, s := range scrape(t, sim, clock, 0) {
+		_, ok := labelValue(s.Labels, "request_id")
+		if name := s.Labels[metricNameIndex(s.Labels)].Value; ok != (name == "mem") {
+			t.Errorf("label bomb not confined to its metrics: labels=%v", s.Labels)
+		}
+		if ok {
+			bombed++
+		}
+	}
+	if bombed == 0 {
+		t.Error("no series with the label bomb")
+	}
+
+	if _, err := parseLabelBomb(map[string]string{"label_bomb_metrics": "mem"}); err == nil {
+		t.Error("expected an error for label bomb metrics without a label")
+	}
+}
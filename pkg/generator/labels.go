@@ -0,0 +1,95 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"text/template"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// LabelTemplateData is the data Labels values are executed with as
+// text/template templates, e.g. "shard-{{.ShardID}}" or "{{.HostIndex}}".
+type LabelTemplateData struct {
+	// HostIndex is the index of the host, unique for the simulator's
+	// lifetime including hosts created by churn.
+	HostIndex int
+	// ShardID identifies the generator instance, see
+	// HostsSimulatorOptions.ShardID.
+	ShardID  int
+	Hostname string
+	Cluster  string
+}
+
+type labelTemplate struct {
+	name  string
+	value *template.Template
+}
+
+// parseLabelTemplates parses the values of labels as templates, sorted by
+// label name so that labels are always added in the same order.
+func parseLabelTemplates(labels map[string]string) ([]labelTemplate, error) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	templates := make([]labelTemplate, 0, len(names))
+	for _, name := range names {
+		t, err := template.New(name).Option("missingkey=error").Parse(labels[name])
+		if err == nil {
+			// Catch references to fields that do not exist upfront
+			err = t.Execute(ioutil.Discard, LabelTemplateData{})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid label template: name=%s, value=%s, err=%v",
+				name, labels[name], err)
+		}
+		templates = append(templates, labelTemplate{name: name, value: t})
+	}
+	return templates, nil
+}
+
+func renderLabelTemplates(
+	templates []labelTemplate,
+	data LabelTemplateData,
+) []prompb.Label {
+	if len(templates) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	result := make([]prompb.Label, 0, len(templates))
+	for _, t := range templates {
+		buf.Reset()
+		if err := t.value.Execute(&buf, data); err != nil {
+			// Templates were checked against the data when parsed
+			panic(fmt.Sprintf("could not execute label template: name=%s, err=%v",
+				t.name, err))
+		}
+		result = append(result, prompb.Label{Name: t.name, Value: buf.String()})
+	}
+	return result
+}
+
+// setLabels sets the given labels on the series labels, replacing the value
+// of any label with the same name.
+func setLabels(seriesLabels []prompb.Label, set []prompb.Label) []prompb.Label {
+	for _, l := range set {
+		replaced := false
+		for i := range seriesLabels {
+			if seriesLabels[i].Name == l.Name {
+				seriesLabels[i].Value = l.Value
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			seriesLabels = append(seriesLabels, l)
+		}
+	}
+	return seriesLabels
+}
@@ -0,0 +1,161 @@
+package generator
+
+import (
+	"strings"
+)
+
+type MetricType string
+
+const (
+	MetricTypeCounter   MetricType = "counter"
+	MetricTypeGauge     MetricType = "gauge"
+	MetricTypeHistogram MetricType = "histogram"
+	MetricTypeSummary   MetricType = "summary"
+	MetricTypeUnknown   MetricType = "unknown"
+)
+
+// MetricMetadata describes a metric family.
+type MetricMetadata struct {
+	// Family is the name of the family, the metric name of its series
+	// without any histogram or summary suffix.
+	Family string
+	Type   MetricType
+	Help   string
+	// Unit is empty unless the family name ends with it.
+	Unit string
+}
+
+// MetadataSimulator is a Simulator that describes the metric families it
+// emits.
+type MetadataSimulator interface {
+	Simulator
+	// MetricMetadata returns the metadata of the family series with the
+	// given metric name belong to.
+	MetricMetadata(name string) MetricMetadata
+}
+
+var (
+	_ MetadataSimulator = (*HostsSimulator)(nil)
+	_ MetadataSimulator = (*KubernetesSimulator)(nil)
+	_ MetadataSimulator = (*HighFrequencySimulator)(nil)
+	_ MetadataSimulator = (*BlendSimulator)(nil)
+)
+
+// hostMeasurementHelp describes the influxdb-comparisons devops
+// measurements, each emitted with a series per field.
+var hostMeasurementHelp = map[string]string{
+	"cpu":       "CPU time spent per mode in percent, by field in the measurement label.",
+	"diskio":    "Disk I/O operations, bytes and time, by field in the measurement label.",
+	"disk":      "Filesystem capacity and inode usage, by field in the measurement label.",
+	"kernel":    "Kernel boot time, interrupts, context switches and process statistics, by field in the measurement label.",
+	"mem":       "Memory usage in bytes and percent, by field in the measurement label.",
+	"net":       "Network interface bytes, packets, errors and drops, by field in the measurement label.",
+	"nginx":     "Nginx connection and request statistics, by field in the measurement label.",
+	"postgresl": "PostgreSQL database statistics, by field in the measurement label.",
+	"redis":     "Redis server statistics, by field in the measurement label.",
+}
+
+func (h *HostsSimulator) MetricMetadata(name string) MetricMetadata {
+	h.RLock()
+	defer h.RUnlock()
+
+	for _, family := range h.histograms {
+		metadata := MetricMetadata{
+			Family: family.Name,
+			Type:   MetricTypeHistogram,
+			Help:   "Simulated request latency distribution by handler.",
+			Unit:   familyUnit(family.Name),
+		}
+		if family.Summary {
+			metadata.Type = MetricTypeSummary
+			if name == family.Name {
+				return metadata
+			}
+		} else if name == family.Name+"_bucket" {
+			return metadata
+		}
+		if name == family.Name+"_sum" || name == family.Name+"_count" {
+			return metadata
+		}
+	}
+
+	// Resolve names from collisions and renames back to the measurement
+	measurement := name
+	for from, to := range h.nameCollisions {
+		if to == name {
+			measurement = from
+		}
+	}
+	for _, rename := range h.metricRenames {
+		if rename.To == name {
+			measurement = rename.From
+		}
+	}
+
+	metadata := MetricMetadata{
+		Family: name,
+		Type:   MetricTypeGauge,
+		Help:   hostMeasurementHelp[measurement],
+	}
+	if metadata.Help == "" {
+		metadata.Help = "Simulated " + measurement + " measurement."
+	}
+	if _, ok := h.counterFamilies[measurement]; ok {
+		metadata.Type = MetricTypeCounter
+	}
+	return metadata
+}
+
+var kubernetesMetadata = map[string]MetricMetadata{
+	"kube_pod_info": {
+		Type: MetricTypeGauge,
+		Help: "Information about pod.",
+	},
+	"kube_pod_owner": {
+		Type: MetricTypeGauge,
+		Help: "Information about the Pod's owner.",
+	},
+	"container_network_receive_bytes_total": {
+		Type: MetricTypeCounter,
+		Help: "Cumulative count of bytes received",
+		Unit: "bytes",
+	},
+	"container_network_transmit_bytes_total": {
+		Type: MetricTypeCounter,
+		Help: "Cumulative count of bytes transmitted",
+		Unit: "bytes",
+	},
+	"container_cpu_usage_seconds_total": {
+		Type: MetricTypeCounter,
+		Help: "Cumulative cpu time consumed in seconds.",
+		Unit: "seconds",
+	},
+	"container_memory_working_set_bytes": {
+		Type: MetricTypeGauge,
+		Help: "Current working set in bytes.",
+		Unit: "bytes",
+	},
+	"kube_pod_container_status_restarts_total": {
+		Type: MetricTypeCounter,
+		Help: "The number of container restarts per container.",
+	},
+}
+
+func (k *KubernetesSimulator) MetricMetadata(name string) MetricMetadata {
+	metadata, ok := kubernetesMetadata[name]
+	if !ok {
+		metadata = MetricMetadata{Type: MetricTypeUnknown}
+	}
+	metadata.Family = name
+	return metadata
+}
+
+// familyUnit returns the unit a family name ends with, if any.
+func familyUnit(name string) string {
+	for _, unit := range []string{"seconds", "bytes", "ratio"} {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	return ""
+}
@@ -0,0 +1,220 @@
+package generator
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// LabelRename renames a label key across the fleet, hosts switch over
+// gradually during the window so both schemas coexist until it completes.
+type LabelRename struct {
+	From   string
+	To     string
+	Start  time.Time
+	Window time.Duration
+}
+
+// RenameLabel starts renaming a label key across the fleet from now over the
+// given window.
+func (h *HostsSimulator) RenameLabel(from, to string, window time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.labelRenames = append(h.labelRenames, LabelRename{
+		From:   from,
+		To:     to,
+		Start:  h.timeNowFn(),
+		Window: window,
+	})
+}
+
+// migrated returns whether the host has switched over at the given time.
+func migrated(
+	host simulatedHost,
+	start time.Time,
+	window time.Duration,
+	now time.Time,
+) bool {
+	if now.Before(start) {
+		return false
+	}
+	elapsed := now.Sub(start)
+	if elapsed >= window {
+		return true
+	}
+	return hostFraction(host) < float64(elapsed)/float64(window)
+}
+
+// hostFraction maps a host uniformly and stably onto [0.0,1.0) so it
+// migrates at the same point of every window.
+func hostFraction(host simulatedHost) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(host.key()))
+	return fingerprintFraction(mixFingerprint(h.Sum64()))
+}
+
+type labelRenameState struct {
+	emittedOld     bool
+	renamedPending bool
+	renamed        bool
+}
+
+// labelRenameStatesWithLock returns the host's rename states, called before
+// its series for a scrape are generated. Renames added after the host was
+// first scraped start out as having emitted the old series.
+func (h *HostsSimulator) labelRenameStatesWithLock(host simulatedHost) []labelRenameState {
+	states := h.labelRenameStates[host.key()]
+	scraped := len(h.familiesEmitted[host.key()]) > 0
+	for len(states) < len(h.labelRenames) {
+		states = append(states, labelRenameState{emittedOld: scraped})
+	}
+	h.labelRenameStates[host.key()] = states
+	return states
+}
+
+// applyLabelRenamesWithLock renames the labels of the series if its host has
+// migrated, returning the labels. A series that already has the To label
+// keeps it and drops the From label, so that label names stay unique. With
+// states it also returns whether the series was renamed for the first time
+// after the host emitted it under the From label, i.e. is a new series.
+func (h *HostsSimulator) applyLabelRenamesWithLock(
+	host simulatedHost,
+	states []labelRenameState,
+	seriesLabels []prompb.Label,
+	now time.Time,
+) ([]prompb.Label, bool) {
+	created := false
+	for i, rename := range h.labelRenames {
+		from, hasTo := -1, false
+		for j := range seriesLabels {
+			switch seriesLabels[j].Name {
+			case rename.From:
+				from = j
+			case rename.To:
+				hasTo = true
+			}
+		}
+		if from < 0 {
+			continue
+		}
+		if !migrated(host, rename.Start, rename.Window, now) {
+			if states != nil {
+				states[i].emittedOld = true
+			}
+			continue
+		}
+		if states != nil && states[i].emittedOld && !states[i].renamed {
+			states[i].renamedPending = true
+			created = true
+		}
+		if hasTo {
+			seriesLabels = append(seriesLabels[:from], seriesLabels[from+1:]...)
+		} else {
+			seriesLabels[from].Name = rename.To
+		}
+	}
+	return seriesLabels, created
+}
+
+// commitLabelRenameStates records that the host's renamed series were
+// counted, called once all of its series for a scrape were generated.
+func commitLabelRenameStates(states []labelRenameState) {
+	for i := range states {
+		if states[i].renamedPending {
+			states[i].renamedPending = false
+			states[i].renamed = true
+		}
+	}
+}
+
+// MetricRename renames a metric family's __name__ across the fleet, hosts
+// switch over gradually during the window and mark the old series stale
+// once they have.
+type MetricRename struct {
+	From   string
+	To     string
+	Start  time.Time
+	Window time.Duration
+}
+
+// RenameMetric starts renaming a metric name across the fleet from now over
+// the given window.
+func (h *HostsSimulator) RenameMetric(from, to string, window time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.metricRenames = append(h.metricRenames, MetricRename{
+		From:   from,
+		To:     to,
+		Start:  h.timeNowFn(),
+		Window: window,
+	})
+}
+
+type metricRenameState struct {
+	emittedOld   bool
+	stalePending bool
+	staled       bool
+}
+
+// metricRenameStatesWithLock returns the host's rename states like
+// labelRenameStatesWithLock.
+func (h *HostsSimulator) metricRenameStatesWithLock(host simulatedHost) []metricRenameState {
+	states := h.metricRenameStates[host.key()]
+	scraped := len(h.familiesEmitted[host.key()]) > 0
+	for len(states) < len(h.metricRenames) {
+		states = append(states, metricRenameState{emittedOld: scraped})
+	}
+	h.metricRenameStates[host.key()] = states
+	return states
+}
+
+// applyMetricRenamesWithLock renames the series if its host has migrated,
+// returning the old labels when the old series should be marked stale.
+func (h *HostsSimulator) applyMetricRenamesWithLock(
+	host simulatedHost,
+	states []metricRenameState,
+	seriesLabels []prompb.Label,
+	now time.Time,
+) []prompb.Label {
+	var stale []prompb.Label
+	for i, rename := range h.metricRenames {
+		idx := metricNameIndex(seriesLabels)
+		if idx < 0 || seriesLabels[idx].Value != rename.From {
+			continue
+		}
+		if !migrated(host, rename.Start, rename.Window, now) {
+			states[i].emittedOld = true
+			continue
+		}
+		if states[i].emittedOld && !states[i].staled {
+			stale = append([]prompb.Label{}, seriesLabels...)
+			states[i].stalePending = true
+		}
+		seriesLabels[idx].Value = rename.To
+	}
+	return stale
+}
+
+// commitMetricRenameStates records that stale markers were emitted for the
+// host, called once all of its series for a scrape were generated.
+func commitMetricRenameStates(states []metricRenameState) {
+	for i := range states {
+		if states[i].stalePending {
+			states[i].stalePending = false
+			states[i].staled = true
+		}
+	}
+}
+
+func metricNameIndex(seriesLabels []prompb.Label) int {
+	for i, l := range seriesLabels {
+		if l.Name == labels.MetricName {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,143 @@
+package generator
+
+import (
+	"testing"
+)
+
+func TestRenamesCountSeriesCreated(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 1, clock, HostsSimulatorOptions{})
+	initial := sim.seriesCreated
+
+	var cpuSeries, hostnameSeries int
+	for _, s := range scrape(t, sim, clock, 0) {
+		if s.Labels[metricNameIndex(s.Labels)].Value == "cpu" {
+			cpuSeries++
+		}
+		if _, ok := labelValue(s.Labels, "hostname"); ok {
+			hostnameSeries++
+		}
+	}
+	if cpuSeries == 0 || hostnameSeries == 0 {
+		t.Fatalf("no series to rename: cpu=%d, hostname=%d", cpuSeries, hostnameSeries)
+	}
+
+	sim.RenameMetric("cpu", "cpu_renamed", 0)
+	for i := 0; i < 2; i++ {
+		scrape(t, sim, clock, 0)
+	}
+	if expected := initial + cpuSeries; sim.seriesCreated != expected {
+		t.Errorf("renamed metric series not counted once: created=%d, expected=%d",
+			sim.seriesCreated, expected)
+	}
+
+	// The cpu series were already renamed, so the label rename creates
+	// every series with the label anew
+	sim.RenameLabel("hostname", "host", 0)
+	for i := 0; i < 2; i++ {
+		scrape(t, sim, clock, 0)
+	}
+	if expected := initial + cpuSeries + hostnameSeries; sim.seriesCreated != expected {
+		t.Errorf("renamed label series not counted once: created=%d, expected=%d",
+			sim.seriesCreated, expected)
+	}
+}
+
+func TestMetricRenameMarksOldSeriesStale(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 2, clock, HostsSimulatorOptions{})
+
+	old := make(map[string]struct{})
+	for _, s := range scrape(t, sim, clock, 0) {
+		if s.Labels[metricNameIndex(s.Labels)].Value == "cpu" {
+			old[seriesKey(s.Labels)] = struct{}{}
+		}
+	}
+
+	sim.RenameMetric("cpu", "cpu_renamed", 0)
+	renamed := 0
+	for _, s := range scrape(t, sim, clock, 0) {
+		name := s.Labels[metricNameIndex(s.Labels)].Value
+		switch {
+		case isStale(s):
+			if _, ok := old[seriesKey(s.Labels)]; !ok || name != "cpu" {
+				t.Errorf("stale marker for a series not renamed: labels=%v", s.Labels)
+			}
+			delete(old, seriesKey(s.Labels))
+		case name == "cpu":
+			t.Errorf("old series emitted after the rename: labels=%v", s.Labels)
+		case name == "cpu_renamed":
+			renamed++
+		}
+	}
+	if len(old) != 0 {
+		t.Errorf("old series not marked stale: remaining=%d", len(old))
+	}
+	if renamed == 0 {
+		t.Error("no renamed series emitted")
+	}
+
+	for _, s := range scrape(t, sim, clock, 0) {
+		if isStale(s) {
+			t.Fatalf("stale markers emitted again: labels=%v", s.Labels)
+		}
+	}
+}
+
+func TestLabelRenameKeepsLabelNamesUnique(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 2, clock, HostsSimulatorOptions{})
+	// Renaming onto a label every series has drops the renamed label
+	sim.RenameLabel("hostname", "region", 0)
+	sim.RenameLabel("rack", "rack_id", 0)
+
+	for _, s := range scrape(t, sim, clock, 0) {
+		names := make(map[string]struct{})
+		for _, l := range s.Labels {
+			if _, ok := names[l.Name]; ok {
+				t.Fatalf("duplicate label name: name=%s, labels=%v", l.Name, s.Labels)
+			}
+			names[l.Name] = struct{}{}
+		}
+		if _, ok := names["hostname"]; ok {
+			t.Errorf("renamed label still set: labels=%v", s.Labels)
+		}
+		if _, ok := names["rack"]; ok {
+			t.Errorf("renamed label still set: labels=%v", s.Labels)
+		}
+		if _, ok := names["rack_id"]; !ok {
+			t.Errorf("label not renamed: labels=%v", s.Labels)
+		}
+	}
+}
+
+func TestRenamesMigrateHostsGradually(t *testing.T) {
+	clock := NewVirtualClock(testStart)
+	sim := newTestHosts(t, 100, clock, HostsSimulatorOptions{})
+	scrape(t, sim, clock, 0)
+
+	sim.RenameLabel("rack", "rack_id", 10*testScrapeInterval)
+	migratedHosts := func() int {
+		hosts := make(map[string]struct{})
+		for _, s := range scrape(t, sim, clock, 0) {
+			if _, ok := labelValue(s.Labels, "rack_id"); ok {
+				hostname, _ := labelValue(s.Labels, "hostname")
+				hosts[hostname] = struct{}{}
+			}
+		}
+		return len(hosts)
+	}
+	halfway := 0
+	for i := 0; i < 5; i++ {
+		halfway = migratedHosts()
+	}
+	if halfway == 0 || halfway == 100 {
+		t.Errorf("hosts not migrated gradually: migrated=%d", halfway)
+	}
+	for i := 0; i < 5; i++ {
+		migratedHosts()
+	}
+	if n := migratedHosts(); n != 100 {
+		t.Errorf("hosts not all migrated after the window: migrated=%d", n)
+	}
+}
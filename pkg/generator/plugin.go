@@ -0,0 +1,20 @@
+package generator
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// LoadSimulatorPlugin opens a Go plugin whose init function registers one or
+// more simulators with RegisterSimulator. The plugin is built with
+// -buildmode=plugin against the same package versions as the command, and
+// plugins are only supported by cgo builds on linux or darwin: with
+// CGO_ENABLED=0, as the Makefile's default builds, opening one fails with
+// "plugin: not implemented".
+func LoadSimulatorPlugin(path string) error {
+	if _, err := plugin.Open(path); err != nil {
+		return fmt.Errorf("unable to open simulator plugin: path=%s, err=%v",
+			path, err)
+	}
+	return nil
+}
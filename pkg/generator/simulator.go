@@ -0,0 +1,279 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// SeriesBatch is the series scraped from each simulated target, keyed by
+// target.
+type SeriesBatch map[string][]prompb.TimeSeries
+
+// Simulator is a workload model producing series for a simulated fleet.
+type Simulator interface {
+	// Generate progresses the simulation and returns the series scraped
+	// from each simulated target.
+	Generate(
+		progressBy, scrapeDuration time.Duration,
+		newSeriesPercent float64,
+	) (SeriesBatch, error)
+	// GenerateStream progresses the simulation like Generate but passes
+	// each series to fn as it is produced, stopping at the first error.
+	GenerateStream(
+		progressBy, scrapeDuration time.Duration,
+		newSeriesPercent float64,
+		fn func(series prompb.TimeSeries) error,
+	) error
+	// ActiveSeries returns the number of series currently being emitted.
+	ActiveSeries() int
+	// Churn immediately replaces the given fraction of series.
+	Churn(newSeriesPercent float64) error
+}
+
+var (
+	_ Simulator = (*HostsSimulator)(nil)
+	_ Simulator = (*KubernetesSimulator)(nil)
+	_ Simulator = (*HighFrequencySimulator)(nil)
+	_ Simulator = (*BlendSimulator)(nil)
+)
+
+type SimulatorOptions struct {
+	// Targets is the size of the simulated population, its unit (hosts,
+	// pods, etc) is up to the simulator.
+	Targets   int
+	Start     time.Time
+	TimeNowFn func() time.Time
+	// Seed when non-zero makes runs with the same options reproducible.
+	Seed int64
+	// MaxSeriesCreated when set caps the cumulative number of series the
+	// simulator creates, generating returns an error once it is exceeded.
+	MaxSeriesCreated int
+	// Params are simulator specific parameters.
+	Params map[string]string
+}
+
+type NewSimulatorFn func(opts SimulatorOptions) (Simulator, error)
+
+const (
+	labelParamPrefix             = "label."
+	labelDistributionParamPrefix = "label_distribution."
+)
+
+var (
+	simulatorsLock sync.RWMutex
+	simulators     = make(map[string]NewSimulatorFn)
+)
+
+func init() {
+	RegisterSimulator("hosts", func(opts SimulatorOptions) (Simulator, error) {
+		// Params prefixed with "label." are Labels templates
+		labels := make(map[string]string)
+		for name, value := range opts.Params {
+			if strings.HasPrefix(name, labelParamPrefix) {
+				labels[strings.TrimPrefix(name, labelParamPrefix)] = value
+			}
+		}
+		if _, err := parseLabelTemplates(labels); err != nil {
+			return nil, err
+		}
+		// Params prefixed with "label_distribution." are LabelDistributions
+		// in the ParseLabelDistribution format
+		distributions := make(map[string]LabelDistribution)
+		for name, value := range opts.Params {
+			if strings.HasPrefix(name, labelDistributionParamPrefix) {
+				d, err := ParseLabelDistribution(value)
+				if err != nil {
+					return nil, err
+				}
+				distributions[strings.TrimPrefix(name, labelDistributionParamPrefix)] = d
+			}
+		}
+		if _, err := compileLabelDistributions(distributions); err != nil {
+			return nil, err
+		}
+		shardID, err := intParam(opts.Params, "shard_id")
+		if err != nil {
+			return nil, err
+		}
+		targetActiveSeries, err := intParam(opts.Params, "target_active_series")
+		if err != nil {
+			return nil, err
+		}
+		constantChurn, err := boolParam(opts.Params, "constant_churn")
+		if err != nil {
+			return nil, err
+		}
+		labelBomb, err := parseLabelBomb(opts.Params)
+		if err != nil {
+			return nil, err
+		}
+		var growth Growth
+		if str, ok := opts.Params["growth"]; ok {
+			if growth, err = ParseGrowth(str); err != nil {
+				return nil, err
+			}
+		}
+		return NewHostsSimulator(opts.Targets, opts.Start, HostsSimulatorOptions{
+			Labels:             labels,
+			LabelDistributions: distributions,
+			ShardID:            shardID,
+			TimeNowFn:          opts.TimeNowFn,
+			Seed:               opts.Seed,
+			TargetActiveSeries: targetActiveSeries,
+			ConstantChurn:      constantChurn,
+			Growth:             growth,
+			LabelBomb:          labelBomb,
+			DuplicateSeries:    opts.Params["duplicate_series"],
+			MaxSeriesCreated:   opts.MaxSeriesCreated,
+		})
+	})
+	RegisterSimulator("kubernetes", func(opts SimulatorOptions) (Simulator, error) {
+		var (
+			kubeOpts = KubernetesSimulatorOptions{
+				TimeNowFn:        opts.TimeNowFn,
+				Seed:             opts.Seed,
+				MaxSeriesCreated: opts.MaxSeriesCreated,
+			}
+			err error
+		)
+		for name, value := range map[string]*int{
+			"namespaces": &kubeOpts.Namespaces,
+			"replicas":   &kubeOpts.ReplicasPerDeployment,
+			"containers": &kubeOpts.ContainersPerPod,
+			"nodes":      &kubeOpts.Nodes,
+		} {
+			if *value, err = intParam(opts.Params, name); err != nil {
+				return nil, err
+			}
+		}
+		return NewKubernetesSimulator(opts.Targets, opts.Start, kubeOpts), nil
+	})
+	RegisterSimulator("highfrequency", func(opts SimulatorOptions) (Simulator, error) {
+		hfOpts := HighFrequencySimulatorOptions{
+			TimeNowFn:        opts.TimeNowFn,
+			Seed:             opts.Seed,
+			MaxSeriesCreated: opts.MaxSeriesCreated,
+		}
+		if str, ok := opts.Params["sample_interval"]; ok {
+			v, err := time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("invalid simulator param: name=sample_interval, value=%s, err=%v",
+					str, err)
+			}
+			hfOpts.SampleInterval = v
+		}
+		var err error
+		if hfOpts.SeriesPerDevice, err = intParam(opts.Params, "series_per_device"); err != nil {
+			return nil, err
+		}
+		return NewHighFrequencySimulator(opts.Targets, hfOpts), nil
+	})
+	RegisterSimulator("blend", newBlendSimulator)
+}
+
+// ParseNameValues parses comma separated name=value pairs such as simulator
+// params, kind naming what they are in errors. An element without "="
+// continues the previous value, so that values can be comma separated lists
+// themselves.
+func ParseNameValues(value, kind string) (map[string]string, error) {
+	result := make(map[string]string)
+	if value == "" {
+		return result, nil
+	}
+	last := ""
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 1 && last != "" {
+			result[last] += "," + pair
+			continue
+		}
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid %s: value=%s", kind, pair)
+		}
+		result[parts[0]] = parts[1]
+		last = parts[0]
+	}
+	return result, nil
+}
+
+// intParam returns the named simulator parameter, or zero if it is not set.
+func intParam(params map[string]string, name string) (int, error) {
+	str, ok := params[name]
+	if !ok {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid simulator param: name=%s, value=%s, err=%v",
+			name, str, err)
+	}
+	return value, nil
+}
+
+// boolParam returns the named simulator parameter, or false if it is not
+// set.
+func boolParam(params map[string]string, name string) (bool, error) {
+	str, ok := params[name]
+	if !ok {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(str)
+	if err != nil {
+		return false, fmt.Errorf("invalid simulator param: name=%s, value=%s, err=%v",
+			name, str, err)
+	}
+	return value, nil
+}
+
+// RegisterSimulator registers a simulator so it can be constructed by name,
+// it is typically called from the init function of the simulator's package
+// or of a Go plugin opened with LoadSimulatorPlugin.
+func RegisterSimulator(name string, fn NewSimulatorFn) {
+	simulatorsLock.Lock()
+	defer simulatorsLock.Unlock()
+
+	if _, ok := simulators[name]; ok {
+		panic(fmt.Sprintf("simulator already registered: name=%s", name))
+	}
+	simulators[name] = fn
+}
+
+func NewSimulator(name string, opts SimulatorOptions) (Simulator, error) {
+	simulatorsLock.RLock()
+	fn, ok := simulators[name]
+	simulatorsLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown simulator: name=%s, registered=%v",
+			name, RegisteredSimulators())
+	}
+	return fn(opts)
+}
+
+func RegisteredSimulators() []string {
+	simulatorsLock.RLock()
+	defer simulatorsLock.RUnlock()
+
+	names := make([]string, 0, len(simulators))
+	for name := range simulators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkSeriesCreated returns an error if adding series would take the
+// cumulative number of series created over the max, zero meaning no max.
+func checkSeriesCreated(created, adding, max int) error {
+	if max <= 0 || created+adding <= max {
+		return nil
+	}
+	return fmt.Errorf("max series created exceeded, halting: created=%d, adding=%d, max=%d",
+		created, adding, max)
+}
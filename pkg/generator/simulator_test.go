@@ -0,0 +1,34 @@
+package generator
+
+import (
+	"testing"
+)
+
+func TestParseNameValues(t *testing.T) {
+	values, err := ParseNameValues("hosts=10,label_bomb_metrics=cpu,mem,churn=0.1", "simulator param")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"hosts":              "10",
+		"label_bomb_metrics": "cpu,mem",
+		"churn":              "0.1",
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("unexpected values: values=%v", values)
+	}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("unexpected value: name=%s, value=%s, expected=%s", name, values[name], value)
+		}
+	}
+
+	if values, err := ParseNameValues("", "simulator param"); err != nil || len(values) != 0 {
+		t.Errorf("expected no values: values=%v, err=%v", values, err)
+	}
+	for _, value := range []string{"cpu", "=10", "hosts=10,,=1"} {
+		if _, err := ParseNameValues(value, "simulator param"); err == nil {
+			t.Errorf("expected an invalid simulator param error: value=%s", value)
+		}
+	}
+}
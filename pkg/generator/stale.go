@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"math"
+	"time"
+
+	"github.com/influxdata/influxdb-comparisons/bulk_data_gen/common"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// staleSeriesWithLock returns a staleness marker for every series the host
+// at the given position in allHosts last emitted, using the labels it would
+// emit them with at the given time. Timestamps are set when emitted.
+func (h *HostsSimulator) staleSeriesWithLock(
+	host simulatedHost,
+	position int,
+	now time.Time,
+) []prompb.TimeSeries {
+	emitted := h.familiesEmitted[host.key()]
+	if len(emitted) == 0 {
+		return nil
+	}
+
+	staleNaN := math.Float64frombits(value.StaleNaN)
+	budget := h.seriesBudgetWithLock(position)
+	fieldIndex := 0
+
+	var result []prompb.TimeSeries
+	for _, measurement := range host.SimulatedMeasurements {
+		p := common.MakeUsablePoint()
+		measurement.ToPoint(p)
+
+		familyIndex := fieldIndex
+		fieldIndex += len(p.FieldKeys)
+		if _, ok := emitted[string(p.MeasurementName)]; !ok {
+			continue
+		}
+
+		for i, fieldName := range p.FieldKeys {
+			if familyIndex+i >= budget {
+				break
+			}
+			seriesLabels := h.seriesLabelsWithLock(host, p, fieldName)
+			seriesLabels, _ = h.applyLabelRenamesWithLock(host, nil, seriesLabels, now)
+			for _, rename := range h.metricRenames {
+				idx := metricNameIndex(seriesLabels)
+				if idx >= 0 && seriesLabels[idx].Value == rename.From &&
+					migrated(host, rename.Start, rename.Window, now) {
+					seriesLabels[idx].Value = rename.To
+				}
+			}
+			result = append(result, prompb.TimeSeries{
+				Labels:  seriesLabels,
+				Samples: []prompb.Sample{{Value: staleNaN}},
+			})
+		}
+	}
+
+	if _, ok := h.histogramStates[host.key()]; ok {
+		h.histogramSeriesWithLock(host, h.debugActiveWithLock(now), 0,
+			budget-fieldIndex, true,
+			func(_ string, series prompb.TimeSeries) error {
+				result = append(result, series)
+				return nil
+			})
+	}
+	return result
+}
@@ -0,0 +1,186 @@
+package generator
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// seriesFingerprint returns a stable hash of a series' labels, used to
+// deterministically assign series to value behaviors.
+func seriesFingerprint(labels []prompb.Label) uint64 {
+	h := fnv.New64a()
+	for _, l := range labels {
+		h.Write([]byte(l.Name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(l.Value))
+		h.Write([]byte{0xff})
+	}
+	return h.Sum64()
+}
+
+// fingerprintFraction maps a fingerprint uniformly onto [0.0,1.0).
+func fingerprintFraction(fingerprint uint64) float64 {
+	return float64(fingerprint>>11) / (1 << 53)
+}
+
+// ValueRange is an inclusive range of sample values, ranges may span
+// negative values and the full magnitude of a float64.
+type ValueRange struct {
+	Min float64
+	Max float64
+	// LogUniform draws values uniformly across orders of magnitude rather
+	// than linearly, e.g. to exercise everything between 1e-300 and 1e300.
+	// Only applies when Min and Max are non-zero and share a sign.
+	LogUniform bool
+}
+
+func (r ValueRange) sample(rng *rand.Rand) float64 {
+	u := rng.Float64()
+	if r.LogUniform && r.Min != 0 && r.Max != 0 &&
+		math.Signbit(r.Min) == math.Signbit(r.Max) {
+		sign := 1.0
+		if r.Min < 0 {
+			sign = -1.0
+		}
+		lo, hi := math.Log(math.Abs(r.Min)), math.Log(math.Abs(r.Max))
+		return sign * math.Exp(lo+u*(hi-lo))
+	}
+	// Interpolate rather than scale by (Max-Min), which overflows for
+	// ranges spanning the full double range
+	return r.Min*(1-u) + r.Max*u
+}
+
+// mixFingerprint scrambles a fingerprint so that fractions derived from it
+// are independent of those derived from the fingerprint itself.
+func mixFingerprint(fingerprint uint64) uint64 {
+	fingerprint ^= fingerprint >> 30
+	fingerprint *= 0xbf58476d1ce4e5b9
+	fingerprint ^= fingerprint >> 27
+	fingerprint *= 0x94d049bb133111eb
+	fingerprint ^= fingerprint >> 31
+	return fingerprint
+}
+
+type heldValue struct {
+	value   float64
+	scrapes int
+}
+
+// heldValueWithLock returns the value to emit for constant and slowly
+// changing series, which hold on to a previously emitted value.
+func (h *HostsSimulator) heldValueWithLock(
+	held map[uint64]*heldValue,
+	fingerprint uint64,
+	v float64,
+) float64 {
+	every := 0
+	switch u := fingerprintFraction(mixFingerprint(fingerprint)); {
+	case u < h.constantPercent:
+		// Never changes
+	case u < h.constantPercent+h.slowPercent:
+		every = h.slowEvery
+	default:
+		return v
+	}
+
+	state, ok := held[fingerprint]
+	if !ok {
+		held[fingerprint] = &heldValue{value: v}
+		return v
+	}
+	state.scrapes++
+	if every > 0 && state.scrapes >= every {
+		state.value = v
+		state.scrapes = 0
+	}
+	return state.value
+}
+
+// hostCountersWithLock returns the counter values of the host, resetting
+// them all at the configured rate to simulate the process restarting.
+func (h *HostsSimulator) hostCountersWithLock(host simulatedHost) map[uint64]float64 {
+	counters := h.counterValues[host.key()]
+	if counters == nil || h.rng.Float64() < h.counterResetPercent {
+		counters = make(map[uint64]float64)
+		h.counterValues[host.key()] = counters
+	}
+	return counters
+}
+
+// counterValue accumulates the series' value into a monotonically
+// increasing counter.
+func counterValue(
+	counters map[uint64]float64,
+	fingerprint uint64,
+	v float64,
+) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return counters[fingerprint]
+	}
+	counters[fingerprint] += math.Abs(v)
+	return counters[fingerprint]
+}
+
+// validateFraction checks an option is a fraction in [0.0,1.0], rejecting
+// NaN which fails every comparison.
+func validateFraction(name string, v float64) error {
+	if !(v >= 0 && v <= 1) {
+		return fmt.Errorf("%s not between [0.0,1.0]: value=%v", name, v)
+	}
+	return nil
+}
+
+// validateValueFractions checks the fractions of the value behaviors are
+// each in [0.0,1.0], and that the NaN and Inf and the constant and slow
+// changing fractions each sum to at most one, as each pair shares one
+// random draw.
+func validateValueFractions(opts HostsSimulatorOptions) error {
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{name: "nanPercent", value: opts.NaNPercent},
+		{name: "infPercent", value: opts.InfPercent},
+		{name: "integerSeriesPercent", value: opts.IntegerSeriesPercent},
+		{name: "constantSeriesPercent", value: opts.ConstantSeriesPercent},
+		{name: "slowChangingSeriesPercent", value: opts.SlowChangingSeriesPercent},
+		{name: "counterResetPercent", value: opts.CounterResetPercent},
+		{name: "clusterOverlapPercent", value: opts.ClusterOverlapPercent},
+	} {
+		if err := validateFraction(f.name, f.value); err != nil {
+			return err
+		}
+	}
+	if opts.NaNPercent+opts.InfPercent > 1 {
+		return fmt.Errorf("nanPercent and infPercent sum to more than 1.0: nanPercent=%v, infPercent=%v",
+			opts.NaNPercent, opts.InfPercent)
+	}
+	if opts.ConstantSeriesPercent+opts.SlowChangingSeriesPercent > 1 {
+		return fmt.Errorf("constantSeriesPercent and slowChangingSeriesPercent sum to more than 1.0: "+
+			"constantSeriesPercent=%v, slowChangingSeriesPercent=%v",
+			opts.ConstantSeriesPercent, opts.SlowChangingSeriesPercent)
+	}
+	return nil
+}
+
+// injectSpecialValueWithLock replaces the value with NaN or +/-Inf at the
+// configured rates.
+func (h *HostsSimulator) injectSpecialValueWithLock(v float64) float64 {
+	if h.nanPercent <= 0 && h.infPercent <= 0 {
+		return v
+	}
+	r := h.rng.Float64()
+	switch {
+	case r < h.nanPercent:
+		return math.NaN()
+	case r < h.nanPercent+h.infPercent/2:
+		return math.Inf(1)
+	case r < h.nanPercent+h.infPercent:
+		return math.Inf(-1)
+	}
+	return v
+}
@@ -5,6 +5,7 @@ go 1.13
 require (
 	github.com/gogo/protobuf v1.3.1 // indirect
 	github.com/golang/protobuf v1.3.3 // indirect
+	github.com/golang/snappy v0.0.1
 	github.com/google/flatbuffers v1.11.0 // indirect
 	github.com/grpc-ecosystem/grpc-gateway v1.12.2 // indirect
 	github.com/influxdata/influxdb-comparisons v0.0.0-20200124215433-077e63e38aa6
@@ -12,5 +13,6 @@ require (
 	github.com/prometheus/prometheus v1.8.2-0.20200201073137-0e912faf4f52
 	golang.org/x/net v0.0.0-20200114155413-6afb5195e5aa // indirect
 	google.golang.org/genproto v0.0.0-20200128133413-58ce757ed39b // indirect
-	google.golang.org/grpc v1.27.0 // indirect
+	google.golang.org/grpc v1.27.0
+	gopkg.in/yaml.v2 v2.2.7
 )
@@ -0,0 +1,169 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerWindow      = 30 * time.Second
+	defaultBreakerMinRequests = 10
+)
+
+// ErrBreakerOpen is returned by writes once the circuit breaker has tripped
+// and aborted sending.
+var ErrBreakerOpen = errors.New("circuit breaker open: backend unhealthy")
+
+// BreakerOptions configure a circuit breaker that stops sending when the
+// backend is failing, so that an ill-sized run does not keep hammering a
+// shared environment. It is disabled unless a threshold is set.
+type BreakerOptions struct {
+	// MaxErrorRate is the fraction (0.0,1.0] of failed requests in a window
+	// above which the breaker trips.
+	MaxErrorRate float64
+	// MaxLatency is the mean request latency in a window above which the
+	// breaker trips.
+	MaxLatency time.Duration
+	// Window is how long a threshold must be exceeded for, thresholds are
+	// evaluated over consecutive windows of this length.
+	Window time.Duration
+	// MinRequests is the number of requests a window needs before it is
+	// evaluated.
+	MinRequests int
+	// PauseFor when set pauses sending for this long each time the breaker
+	// trips, rather than aborting all further writes.
+	PauseFor time.Duration
+	// OnTrip is called each time the breaker trips.
+	OnTrip func(event BreakerEvent)
+}
+
+func (o BreakerOptions) enabled() bool {
+	return o.MaxErrorRate > 0 || o.MaxLatency > 0
+}
+
+// BreakerEvent describes why the breaker tripped.
+type BreakerEvent struct {
+	At          time.Time
+	Requests    int
+	ErrorRate   float64
+	MeanLatency time.Duration
+	// Until is when sending resumes, zero if sending was aborted.
+	Until time.Time
+}
+
+func (e BreakerEvent) String() string {
+	action := "aborting"
+	if !e.Until.IsZero() {
+		action = fmt.Sprintf("pausing until %s", e.Until.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("circuit breaker tripped, %s: requests=%d, errorRate=%.3f, meanLatency=%s",
+		action, e.Requests, e.ErrorRate, e.MeanLatency)
+}
+
+type breaker struct {
+	sync.Mutex
+	opts        BreakerOptions
+	windowStart time.Time
+	requests    int
+	failures    int
+	latency     time.Duration
+	pausedUntil time.Time
+	aborted     bool
+	trips       int64
+}
+
+func newBreaker(opts BreakerOptions) *breaker {
+	if opts.Window <= 0 {
+		opts.Window = defaultBreakerWindow
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = defaultBreakerMinRequests
+	}
+	return &breaker{
+		opts:        opts,
+		windowStart: time.Now(),
+	}
+}
+
+// wait blocks while the breaker is paused, returning ErrBreakerOpen once it
+// has aborted.
+func (b *breaker) wait(ctx context.Context) error {
+	b.Lock()
+	aborted, until := b.aborted, b.pausedUntil
+	b.Unlock()
+
+	if aborted {
+		return ErrBreakerOpen
+	}
+	if d := time.Until(until); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (b *breaker) record(latency time.Duration, failed bool) {
+	b.Lock()
+	now := time.Now()
+	b.requests++
+	b.latency += latency
+	if failed {
+		b.failures++
+	}
+	if now.Sub(b.windowStart) < b.opts.Window {
+		b.Unlock()
+		return
+	}
+
+	event, tripped := b.evaluateWithLock(now)
+	b.windowStart = now
+	b.requests, b.failures, b.latency = 0, 0, 0
+	b.Unlock()
+
+	if tripped && b.opts.OnTrip != nil {
+		b.opts.OnTrip(event)
+	}
+}
+
+func (b *breaker) evaluateWithLock(now time.Time) (BreakerEvent, bool) {
+	if b.requests < b.opts.MinRequests || b.aborted {
+		return BreakerEvent{}, false
+	}
+
+	errorRate := float64(b.failures) / float64(b.requests)
+	meanLatency := b.latency / time.Duration(b.requests)
+	exceeded := (b.opts.MaxErrorRate > 0 && errorRate > b.opts.MaxErrorRate) ||
+		(b.opts.MaxLatency > 0 && meanLatency > b.opts.MaxLatency)
+	if !exceeded {
+		return BreakerEvent{}, false
+	}
+
+	b.trips++
+	event := BreakerEvent{
+		At:          now,
+		Requests:    b.requests,
+		ErrorRate:   errorRate,
+		MeanLatency: meanLatency,
+	}
+	if b.opts.PauseFor > 0 {
+		b.pausedUntil = now.Add(b.opts.PauseFor)
+		event.Until = b.pausedUntil
+	} else {
+		b.aborted = true
+	}
+	return event, true
+}
+
+func (b *breaker) tripCount() int64 {
+	b.Lock()
+	defer b.Unlock()
+	return b.trips
+}
@@ -0,0 +1,96 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// connTransport writes encoded batches to a TCP or UDP socket, redialing
+// after a failed write. Writes are serialized so lines sent by concurrent
+// batches never interleave.
+type connTransport struct {
+	sync.Mutex
+	protocol string
+	network  string
+	addr     string
+	timeout  time.Duration
+	// packetSize when set splits each body at line boundaries into writes
+	// of at most this many bytes, one datagram each.
+	packetSize int
+	encodeFn   func(batch []prompb.TimeSeries) ([]byte, error)
+	conn       net.Conn
+}
+
+func (t *connTransport) encode(batch []prompb.TimeSeries) ([]byte, error) {
+	return t.encodeFn(batch)
+}
+
+func (t *connTransport) post(ctx context.Context, body []byte) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if err := t.writeWithLock(ctx, body); err != nil {
+		if t.conn != nil {
+			t.conn.Close()
+			t.conn = nil
+		}
+		return fmt.Errorf("%s write failed: addr=%s, err=%v",
+			t.protocol, t.addr, err)
+	}
+	return nil
+}
+
+func (t *connTransport) writeWithLock(ctx context.Context, body []byte) error {
+	if t.conn == nil {
+		dialer := net.Dialer{Timeout: t.timeout}
+		conn, err := dialer.DialContext(ctx, t.network, t.addr)
+		if err != nil {
+			return err
+		}
+		t.conn = conn
+	}
+	if err := t.conn.SetWriteDeadline(time.Now().Add(t.timeout)); err != nil {
+		return err
+	}
+
+	if t.packetSize <= 0 {
+		_, err := t.conn.Write(body)
+		return err
+	}
+	for len(body) > 0 {
+		n := len(body)
+		if n > t.packetSize {
+			// Lines longer than a packet are sent on their own
+			n = bytes.LastIndexByte(body[:t.packetSize], '\n') + 1
+			if n == 0 {
+				n = bytes.IndexByte(body, '\n') + 1
+			}
+			if n == 0 {
+				n = len(body)
+			}
+		}
+		if _, err := t.conn.Write(bytes.TrimSuffix(body[:n], []byte("\n"))); err != nil {
+			return err
+		}
+		body = body[n:]
+	}
+	return nil
+}
+
+func (t *connTransport) close() error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
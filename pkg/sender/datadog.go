@@ -0,0 +1,153 @@
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sink"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	defaultDatadogURL       = "https://api.datadoghq.com/api/v2/series"
+	defaultDatadogHostLabel = "hostname"
+
+	datadogGaugeType = 3
+)
+
+func init() {
+	sink.Register("datadog", func(opts sink.Options) (sink.Sink, error) {
+		senderOpts, err := parseSinkOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return NewDatadogSender(DatadogOptions{
+			Options:   senderOpts,
+			APIKey:    opts.Params["api_key"],
+			HostLabel: opts.Params["host_label"],
+		})
+	})
+}
+
+type DatadogOptions struct {
+	// Options configure batching, concurrency and the breaker as for remote
+	// write. URL defaults to the US1 site's series endpoint.
+	Options
+	// APIKey defaults to the DD_API_KEY environment variable.
+	APIKey string
+	// HostLabel is the label sent as each series' host resource rather than
+	// as a tag, defaults to hostname.
+	HostLabel string
+}
+
+// NewDatadogSender returns a sender that submits series to the Datadog
+// metrics API as gzipped v2 series JSON. Every series is sent as a gauge as
+// the simulators produce cumulative values, with labels other than the
+// metric name and host as name:value tags. NaN and infinite samples,
+// including staleness markers, are dropped.
+func NewDatadogSender(opts DatadogOptions) (*Sender, error) {
+	if opts.URL == "" {
+		opts.URL = defaultDatadogURL
+	}
+	if err := validateHTTPURL("datadog", opts.URL); err != nil {
+		return nil, err
+	}
+	if opts.APIKey == "" {
+		opts.APIKey = os.Getenv("DD_API_KEY")
+	}
+	if opts.APIKey == "" {
+		return nil, fmt.Errorf("no datadog api key: url=%s", opts.URL)
+	}
+	if opts.HostLabel == "" {
+		opts.HostLabel = defaultDatadogHostLabel
+	}
+	opts.Options = opts.Options.withDefaults()
+
+	hostLabel := opts.HostLabel
+	return newSender(opts.Options, &httpTransport{
+		protocol: "datadog submit",
+		url:      opts.URL,
+		client:   opts.httpClient(),
+		timeout:  opts.Timeout,
+		headers: map[string]string{
+			"Content-Encoding": "gzip",
+			"Content-Type":     "application/json",
+			"DD-API-KEY":       opts.APIKey,
+			"User-Agent":       userAgent,
+		},
+		extraHeaders: opts.Headers,
+		encodeFn: func(batch []prompb.TimeSeries) ([]byte, error) {
+			return encodeDatadogSeries(batch, hostLabel)
+		},
+	}), nil
+}
+
+type datadogPayload struct {
+	Series []datadogSeries `json:"series"`
+}
+
+type datadogSeries struct {
+	Metric    string            `json:"metric"`
+	Type      int               `json:"type"`
+	Points    []datadogPoint    `json:"points"`
+	Tags      []string          `json:"tags,omitempty"`
+	Resources []datadogResource `json:"resources,omitempty"`
+}
+
+type datadogPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type datadogResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func encodeDatadogSeries(batch []prompb.TimeSeries, hostLabel string) ([]byte, error) {
+	payload := datadogPayload{Series: make([]datadogSeries, 0, len(batch))}
+	for _, series := range batch {
+		s := datadogSeries{
+			Type: datadogGaugeType,
+			Tags: make([]string, 0, len(series.Labels)),
+		}
+		for _, l := range series.Labels {
+			switch l.Name {
+			case labels.MetricName:
+				s.Metric = l.Value
+			case hostLabel:
+				s.Resources = []datadogResource{{Name: l.Value, Type: "host"}}
+			default:
+				s.Tags = append(s.Tags, l.Name+":"+l.Value)
+			}
+		}
+		for _, sample := range series.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			s.Points = append(s.Points, datadogPoint{
+				Timestamp: sample.Timestamp / 1000,
+				Value:     sample.Value,
+			})
+		}
+		if len(s.Points) > 0 {
+			payload.Series = append(payload.Series, s)
+		}
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(payload); err != nil {
+		return nil, fmt.Errorf("could not encode datadog series: err=%v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("could not compress datadog series: err=%v", err)
+	}
+	return buf.Bytes(), nil
+}
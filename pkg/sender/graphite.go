@@ -0,0 +1,131 @@
+package sender
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sink"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// GraphiteFormatTagged writes series as tagged metrics, e.g.
+	// cpu;hostname=host_0;measurement=usage_user.
+	GraphiteFormatTagged = "tagged"
+	// GraphiteFormatPath writes series as dotted paths of the metric name
+	// followed by the label values in label name order, e.g.
+	// cpu.host_0.usage_user.
+	GraphiteFormatPath = "path"
+)
+
+var (
+	graphiteTagEscaper  = strings.NewReplacer(";", "_", "~", "_", "=", "_", " ", "_", "\n", "_")
+	graphitePathEscaper = strings.NewReplacer(".", "_", " ", "_", "\n", "_")
+)
+
+func init() {
+	sink.Register("graphite", func(opts sink.Options) (sink.Sink, error) {
+		senderOpts, err := parseSinkOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return NewGraphiteSender(GraphiteOptions{
+			Options: senderOpts,
+			Format:  opts.Params["format"],
+		})
+	})
+}
+
+type GraphiteOptions struct {
+	// Options configure batching, concurrency and the breaker as for remote
+	// write. URL is the host:port of the carbon plaintext listener, e.g.
+	// localhost:2003.
+	Options
+	// Format is GraphiteFormatTagged, the default, or GraphiteFormatPath.
+	Format string
+}
+
+// NewGraphiteSender returns a sender that writes series to carbon over TCP
+// in the plaintext protocol with second precision timestamps. NaN and
+// infinite samples, including staleness markers, are dropped.
+func NewGraphiteSender(opts GraphiteOptions) (*Sender, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("no graphite address")
+	}
+	if opts.Format == "" {
+		opts.Format = GraphiteFormatTagged
+	}
+	if opts.Format != GraphiteFormatTagged && opts.Format != GraphiteFormatPath {
+		return nil, fmt.Errorf("unknown graphite format: format=%s, supported=%v",
+			opts.Format, []string{GraphiteFormatTagged, GraphiteFormatPath})
+	}
+	opts.Options = opts.Options.withDefaults()
+
+	tagged := opts.Format == GraphiteFormatTagged
+	return newSender(opts.Options, &connTransport{
+		protocol: "graphite",
+		network:  "tcp",
+		addr:     opts.URL,
+		timeout:  opts.Timeout,
+		encodeFn: func(batch []prompb.TimeSeries) ([]byte, error) {
+			return encodeGraphiteLines(batch, tagged), nil
+		},
+	}), nil
+}
+
+func encodeGraphiteLines(batch []prompb.TimeSeries, tagged bool) []byte {
+	var (
+		buf  bytes.Buffer
+		tags []prompb.Label
+	)
+	for _, series := range batch {
+		name := ""
+		tags = tags[:0]
+		for _, l := range series.Labels {
+			if l.Name == labels.MetricName {
+				name = l.Value
+			} else if l.Value != "" {
+				tags = append(tags, l)
+			}
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			return tags[i].Name < tags[j].Name
+		})
+
+		var path strings.Builder
+		if tagged {
+			path.WriteString(graphiteTagEscaper.Replace(name))
+			for _, tag := range tags {
+				path.WriteByte(';')
+				path.WriteString(graphiteTagEscaper.Replace(tag.Name))
+				path.WriteByte('=')
+				path.WriteString(graphiteTagEscaper.Replace(tag.Value))
+			}
+		} else {
+			path.WriteString(graphitePathEscaper.Replace(name))
+			for _, tag := range tags {
+				path.WriteByte('.')
+				path.WriteString(graphitePathEscaper.Replace(tag.Value))
+			}
+		}
+
+		for _, sample := range series.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			buf.WriteString(path.String())
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatInt(sample.Timestamp/1000, 10))
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,16 @@
+package sender
+
+import (
+	"testing"
+)
+
+func TestEncodeGraphiteLinesEscapesNewlines(t *testing.T) {
+	for tagged, expected := range map[bool]string{
+		true:  "cpu_usage;host_name=a_b 1 1\n",
+		false: "cpu_usage.a_b 1 1\n",
+	} {
+		if lines := string(encodeGraphiteLines(newlineSeries(), tagged)); lines != expected {
+			t.Errorf("unexpected lines: tagged=%v, expected=%q, actual=%q", tagged, expected, lines)
+		}
+	}
+}
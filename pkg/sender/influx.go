@@ -0,0 +1,179 @@
+package sender
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sink"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// defaultInfluxFieldLabel is the label the hosts simulator puts the
+	// influxdb-comparisons field name of each series in.
+	defaultInfluxFieldLabel = "measurement"
+	defaultInfluxField      = "value"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	influxTagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+func init() {
+	sink.Register("influx", func(opts sink.Options) (sink.Sink, error) {
+		senderOpts, err := parseSinkOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return NewInfluxSender(InfluxOptions{
+			Options:    senderOpts,
+			FieldLabel: opts.Params["field_label"],
+			Token:      opts.Params["token"],
+		})
+	})
+}
+
+type InfluxOptions struct {
+	// Options configure batching, concurrency and the breaker as for remote
+	// write. URL is the write endpoint including the database, e.g.
+	// http://localhost:8086/write?db=benchmark.
+	Options
+	// FieldLabel is the label holding the field name of each series, series
+	// with the same metric name and remaining labels are written as one point
+	// with a field each, as influxdb-comparisons writes them. Series without
+	// it are written with a single "value" field. Defaults to measurement.
+	FieldLabel string
+	// Token when set is sent as an InfluxDB token Authorization header.
+	Token string
+}
+
+// NewInfluxSender returns a sender that posts series to an InfluxDB /write
+// endpoint as line protocol with millisecond precision, the metric name as
+// the measurement and the remaining labels as tags. NaN and infinite samples,
+// including staleness markers, are dropped as line protocol cannot carry
+// them.
+func NewInfluxSender(opts InfluxOptions) (*Sender, error) {
+	if err := validateHTTPURL("influx", opts.URL); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	if query.Get("precision") == "" {
+		query.Set("precision", "ms")
+	} else if query.Get("precision") != "ms" {
+		return nil, fmt.Errorf("influx write url must use millisecond precision: url=%s",
+			opts.URL)
+	}
+	u.RawQuery = query.Encode()
+
+	if opts.FieldLabel == "" {
+		opts.FieldLabel = defaultInfluxFieldLabel
+	}
+	opts.Options = opts.Options.withDefaults()
+
+	headers := map[string]string{
+		"Content-Type": "text/plain; charset=utf-8",
+		"User-Agent":   userAgent,
+	}
+	if opts.Token != "" {
+		headers["Authorization"] = "Token " + opts.Token
+	}
+	fieldLabel := opts.FieldLabel
+	return newSender(opts.Options, &httpTransport{
+		protocol:     "influx write",
+		url:          u.String(),
+		client:       opts.httpClient(),
+		timeout:      opts.Timeout,
+		headers:      headers,
+		extraHeaders: opts.Headers,
+		encodeFn: func(batch []prompb.TimeSeries) ([]byte, error) {
+			return encodeInfluxLines(batch, fieldLabel), nil
+		},
+	}), nil
+}
+
+type influxPoint struct {
+	// series is the escaped measurement and tag set.
+	series    string
+	fields    []string
+	timestamp int64
+}
+
+// encodeInfluxLines encodes the batch as line protocol, merging the fields
+// of series that only differ by their field label into one point per
+// timestamp.
+func encodeInfluxLines(batch []prompb.TimeSeries, fieldLabel string) []byte {
+	var (
+		points  []*influxPoint
+		byPoint = make(map[string]*influxPoint)
+		tags    []prompb.Label
+		key     strings.Builder
+	)
+	for _, series := range batch {
+		measurement, field := "", defaultInfluxField
+		tags = tags[:0]
+		for _, l := range series.Labels {
+			switch {
+			case l.Name == labels.MetricName:
+				measurement = l.Value
+			case l.Name == fieldLabel:
+				field = l.Value
+			case l.Value != "":
+				// Line protocol does not allow empty tag values
+				tags = append(tags, l)
+			}
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			return tags[i].Name < tags[j].Name
+		})
+
+		key.Reset()
+		key.WriteString(influxMeasurementEscaper.Replace(measurement))
+		for _, tag := range tags {
+			key.WriteByte(',')
+			key.WriteString(influxTagEscaper.Replace(tag.Name))
+			key.WriteByte('=')
+			key.WriteString(influxTagEscaper.Replace(tag.Value))
+		}
+		seriesKey := key.String()
+
+		for _, sample := range series.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			fieldValue := influxTagEscaper.Replace(field) + "=" +
+				strconv.FormatFloat(sample.Value, 'g', -1, 64)
+
+			pointKey := seriesKey + " " + strconv.FormatInt(sample.Timestamp, 10)
+			point, ok := byPoint[pointKey]
+			if !ok {
+				point = &influxPoint{series: seriesKey, timestamp: sample.Timestamp}
+				byPoint[pointKey] = point
+				points = append(points, point)
+			}
+			point.fields = append(point.fields, fieldValue)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, point := range points {
+		buf.WriteString(point.series)
+		buf.WriteByte(' ')
+		buf.WriteString(strings.Join(point.fields, ","))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(point.timestamp, 10))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
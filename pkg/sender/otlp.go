@@ -0,0 +1,195 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sink"
+
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// OTLPProtocolHTTP posts requests to an OTLP/HTTP endpoint, e.g.
+	// http://localhost:4318/v1/metrics.
+	OTLPProtocolHTTP = "http"
+	// OTLPProtocolGRPC calls the OTLP metrics service at a host:port, e.g.
+	// localhost:4317.
+	OTLPProtocolGRPC = "grpc"
+
+	otlpExportMethod         = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	otlpServiceNameAttribute = "service.name"
+	resourceParamPrefix      = "resource."
+)
+
+func init() {
+	sink.Register("otlp", func(opts sink.Options) (sink.Sink, error) {
+		senderOpts, err := parseSinkOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		otlpOpts := OTLPOptions{
+			Options:            senderOpts,
+			Protocol:           opts.Params["protocol"],
+			ResourceAttributes: make(map[string]string),
+		}
+		if str, ok := opts.Params["tls"]; ok {
+			v, err := strconv.ParseBool(str)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sink param: name=tls, value=%s, err=%v",
+					str, err)
+			}
+			otlpOpts.TLS = v
+		}
+		for name, value := range opts.Params {
+			if strings.HasPrefix(name, resourceParamPrefix) {
+				otlpOpts.ResourceAttributes[strings.TrimPrefix(name, resourceParamPrefix)] = value
+			}
+		}
+		return NewOTLPSender(otlpOpts)
+	})
+}
+
+type OTLPOptions struct {
+	// Options configure batching, concurrency and the breaker as for remote
+	// write. URL is the OTLP/HTTP metrics URL, or the host:port of the
+	// collector when using gRPC.
+	Options
+	// Protocol is OTLPProtocolHTTP, the default, or OTLPProtocolGRPC.
+	Protocol string
+	// TLS dials gRPC endpoints with TLS, OTLP/HTTP uses the URL scheme.
+	TLS bool
+	// ResourceAttributes are set on the resource every series is sent
+	// under, service.name defaults to the sender's user agent.
+	ResourceAttributes map[string]string
+}
+
+// NewOTLPSender returns a sender that converts series to OTLP metrics
+// export requests. Series named *_total are sent as cumulative monotonic
+// sums, starting at the first sample sent of each, and every other series,
+// including those of histograms and summaries, as gauges. Labels other than
+// the metric name become data point attributes.
+func NewOTLPSender(opts OTLPOptions) (*Sender, error) {
+	if opts.Protocol == "" {
+		opts.Protocol = OTLPProtocolHTTP
+	}
+	opts.Options = opts.Options.withDefaults()
+
+	resource := make(map[string]string, len(opts.ResourceAttributes)+1)
+	resource[otlpServiceNameAttribute] = userAgent
+	for name, value := range opts.ResourceAttributes {
+		resource[name] = value
+	}
+	starts := newOTLPStartTimes()
+	encodeFn := func(batch []prompb.TimeSeries) ([]byte, error) {
+		return encodeOTLPMetrics(batch, resource, starts), nil
+	}
+
+	switch opts.Protocol {
+	case OTLPProtocolHTTP:
+		if err := validateHTTPURL("otlp", opts.URL); err != nil {
+			return nil, err
+		}
+		return newSender(opts.Options, &httpTransport{
+			protocol: "otlp export",
+			url:      opts.URL,
+			client:   opts.httpClient(),
+			timeout:  opts.Timeout,
+			headers: map[string]string{
+				"Content-Type": "application/x-protobuf",
+				"User-Agent":   userAgent,
+			},
+			extraHeaders: opts.Headers,
+			encodeFn:     encodeFn,
+		}), nil
+	case OTLPProtocolGRPC:
+		creds := grpc.WithInsecure()
+		if opts.TLS {
+			creds = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+		}
+		conn, err := grpc.Dial(opts.URL, creds, grpc.WithUserAgent(userAgent))
+		if err != nil {
+			return nil, fmt.Errorf("could not dial otlp endpoint: endpoint=%s, err=%v",
+				opts.URL, err)
+		}
+		return newSender(opts.Options, &grpcTransport{
+			endpoint: opts.URL,
+			conn:     conn,
+			timeout:  opts.Timeout,
+			md:       metadata.New(opts.Headers),
+			encodeFn: encodeFn,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol: protocol=%s, supported=%v",
+			opts.Protocol, []string{OTLPProtocolHTTP, OTLPProtocolGRPC})
+	}
+}
+
+// grpcTransport calls the OTLP metrics service Export method with requests
+// encoded up front, so no generated OTLP types are needed.
+type grpcTransport struct {
+	endpoint string
+	conn     *grpc.ClientConn
+	timeout  time.Duration
+	md       metadata.MD
+	encodeFn func(batch []prompb.TimeSeries) ([]byte, error)
+}
+
+func (t *grpcTransport) encode(batch []prompb.TimeSeries) ([]byte, error) {
+	return t.encodeFn(batch)
+}
+
+func (t *grpcTransport) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	if len(t.md) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, t.md)
+	}
+
+	var resp rawMessage
+	err := t.conn.Invoke(ctx, otlpExportMethod, rawMessage(body), &resp,
+		grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		return fmt.Errorf("otlp export failed: endpoint=%s, err=%v",
+			t.endpoint, err)
+	}
+	return nil
+}
+
+func (t *grpcTransport) close() error {
+	return t.conn.Close()
+}
+
+// rawMessage is an already encoded protobuf message.
+type rawMessage []byte
+
+// rawCodec passes rawMessages through to and from the wire as is.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(rawMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type: type=%T", v)
+	}
+	return m, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*rawMessage)
+	if !ok {
+		return fmt.Errorf("unexpected message type: type=%T", v)
+	}
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
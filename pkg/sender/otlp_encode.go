@@ -0,0 +1,225 @@
+package sender
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Field numbers of the OTLP metrics protos used, from
+// opentelemetry/proto/collector/metrics/v1 and opentelemetry/proto/metrics/v1.
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+
+	exportRequestResourceMetrics = 1
+
+	resourceMetricsResource     = 1
+	resourceMetricsScopeMetrics = 2
+	resourceAttributes          = 1
+
+	scopeMetricsScope   = 1
+	scopeMetricsMetrics = 2
+	scopeName           = 1
+
+	metricName  = 1
+	metricGauge = 5
+	metricSum   = 7
+
+	gaugeDataPoints                  = 1
+	sumAggregationTemporality        = 2
+	sumIsMonotonic                   = 3
+	aggregationTemporalityCumulative = 2
+
+	dataPointStartTimeUnixNano = 2
+	dataPointTimeUnixNano      = 3
+	dataPointAsDouble          = 4
+	dataPointAttributes        = 7
+	dataPointFlags             = 8
+	dataPointNoValueFlag       = 1
+
+	keyValueKey         = 1
+	keyValueValue       = 2
+	anyValueStringValue = 1
+)
+
+// otlpStartTimes remembers the first timestamp of every cumulative sum
+// series sent, its start time, for as long as the sender lives.
+type otlpStartTimes struct {
+	sync.Mutex
+	starts map[uint64]int64
+}
+
+func newOTLPStartTimes() *otlpStartTimes {
+	return &otlpStartTimes{starts: make(map[uint64]int64)}
+}
+
+// startOf returns the start time in milliseconds of the series, recording
+// its first sample's timestamp if it was not sent before.
+func (s *otlpStartTimes) startOf(series prompb.TimeSeries) int64 {
+	h := fnv.New64a()
+	for _, l := range series.Labels {
+		h.Write([]byte(l.Name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(l.Value))
+		h.Write([]byte{0xff})
+	}
+	fingerprint := h.Sum64()
+
+	s.Lock()
+	defer s.Unlock()
+
+	start, ok := s.starts[fingerprint]
+	if !ok && len(series.Samples) > 0 {
+		start = series.Samples[0].Timestamp
+		s.starts[fingerprint] = start
+	}
+	return start
+}
+
+// encodeOTLPMetrics encodes the batch as an ExportMetricsServiceRequest with
+// a single resource and scope, and one metric per metric name. Cumulative
+// sums get their start time from starts.
+func encodeOTLPMetrics(
+	batch []prompb.TimeSeries,
+	resource map[string]string,
+	starts *otlpStartTimes,
+) []byte {
+	var (
+		names  []string
+		byName = make(map[string][]prompb.TimeSeries)
+	)
+	for _, series := range batch {
+		name := ""
+		for _, l := range series.Labels {
+			if l.Name == labels.MetricName {
+				name = l.Value
+				break
+			}
+		}
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], series)
+	}
+
+	var scopeMetrics []byte
+	scopeMetrics = appendBytesField(scopeMetrics, scopeMetricsScope,
+		appendStringField(nil, scopeName, userAgent))
+	for _, name := range names {
+		scopeMetrics = appendBytesField(scopeMetrics, scopeMetricsMetrics,
+			encodeOTLPMetric(name, byName[name], starts))
+	}
+
+	resourceNames := make([]string, 0, len(resource))
+	for name := range resource {
+		resourceNames = append(resourceNames, name)
+	}
+	sort.Strings(resourceNames)
+	var res []byte
+	for _, name := range resourceNames {
+		res = appendBytesField(res, resourceAttributes,
+			encodeKeyValue(name, resource[name]))
+	}
+
+	var resourceMetrics []byte
+	resourceMetrics = appendBytesField(resourceMetrics, resourceMetricsResource, res)
+	resourceMetrics = appendBytesField(resourceMetrics, resourceMetricsScopeMetrics, scopeMetrics)
+
+	return appendBytesField(nil, exportRequestResourceMetrics, resourceMetrics)
+}
+
+func encodeOTLPMetric(name string, series []prompb.TimeSeries, starts *otlpStartTimes) []byte {
+	cumulative := strings.HasSuffix(name, "_total")
+	var points []byte
+	for _, s := range series {
+		var start int64
+		if cumulative {
+			start = starts.startOf(s)
+		}
+		var attributes []byte
+		for _, l := range s.Labels {
+			if l.Name == labels.MetricName {
+				continue
+			}
+			attributes = appendBytesField(attributes, dataPointAttributes,
+				encodeKeyValue(l.Name, l.Value))
+		}
+		for _, sample := range s.Samples {
+			point := append([]byte(nil), attributes...)
+			if cumulative {
+				if sample.Timestamp < start {
+					start = sample.Timestamp
+				}
+				point = appendFixed64Field(point, dataPointStartTimeUnixNano,
+					uint64(start)*1e6)
+			}
+			point = appendFixed64Field(point, dataPointTimeUnixNano,
+				uint64(sample.Timestamp)*1e6)
+			if value.IsStaleNaN(sample.Value) {
+				point = appendVarintField(point, dataPointFlags, dataPointNoValueFlag)
+			} else {
+				point = appendFixed64Field(point, dataPointAsDouble,
+					math.Float64bits(sample.Value))
+			}
+			points = appendBytesField(points, gaugeDataPoints, point)
+		}
+	}
+
+	metric := appendStringField(nil, metricName, name)
+	if cumulative {
+		// Data points are encoded under gaugeDataPoints, which is also the
+		// field number of a sum's data points
+		sum := appendVarintField(points, sumAggregationTemporality, aggregationTemporalityCumulative)
+		sum = appendVarintField(sum, sumIsMonotonic, 1)
+		return appendBytesField(metric, metricSum, sum)
+	}
+	return appendBytesField(metric, metricGauge, points)
+}
+
+func encodeKeyValue(key, value string) []byte {
+	kv := appendStringField(nil, keyValueKey, key)
+	return appendBytesField(kv, keyValueValue,
+		appendStringField(nil, anyValueStringValue, value))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return appendVarint(b, uint64(field<<3|wireType))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	return appendVarint(appendTag(b, field, protoWireVarint), v)
+}
+
+func appendFixed64Field(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, protoWireFixed64)
+	for i := uint(0); i < 8; i++ {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = appendVarint(appendTag(b, field, protoWireBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendStringField(b []byte, field int, s string) []byte {
+	b = appendVarint(appendTag(b, field, protoWireBytes), uint64(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,248 @@
+package sender
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// protoField is a field decoded from the protobuf wire format.
+type protoField struct {
+	number   int
+	wireType int
+	varint   uint64
+	fixed64  uint64
+	bytes    []byte
+}
+
+// decodeProto decodes the fields of a message, failing the test on any
+// malformed or unsupported wire type so that the encoding is checked
+// independently of how it was produced.
+func decodeProto(t *testing.T, b []byte) []protoField {
+	t.Helper()
+
+	var fields []protoField
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("invalid tag: remaining=%x", b)
+		}
+		b = b[n:]
+		field := protoField{number: int(tag >> 3), wireType: int(tag & 7)}
+		switch field.wireType {
+		case protoWireVarint:
+			field.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("invalid varint: field=%d", field.number)
+			}
+			b = b[n:]
+		case protoWireFixed64:
+			if len(b) < 8 {
+				t.Fatalf("truncated fixed64: field=%d", field.number)
+			}
+			field.fixed64 = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case protoWireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				t.Fatalf("invalid length delimited field: field=%d", field.number)
+			}
+			field.bytes = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type: field=%d, wireType=%d", field.number, field.wireType)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func protoFields(fields []protoField, number int) []protoField {
+	var result []protoField
+	for _, f := range fields {
+		if f.number == number {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func protoString(t *testing.T, fields []protoField, number int) string {
+	t.Helper()
+
+	matched := protoFields(fields, number)
+	if len(matched) != 1 {
+		t.Fatalf("expected one string field: field=%d, found=%d", number, len(matched))
+	}
+	return string(matched[0].bytes)
+}
+
+func decodeKeyValues(t *testing.T, fields []protoField) map[string]string {
+	t.Helper()
+
+	result := make(map[string]string)
+	for _, kv := range fields {
+		kvFields := decodeProto(t, kv.bytes)
+		anyValue := protoFields(kvFields, keyValueValue)
+		if len(anyValue) != 1 {
+			t.Fatalf("expected one key value value: found=%d", len(anyValue))
+		}
+		result[protoString(t, kvFields, keyValueKey)] =
+			protoString(t, decodeProto(t, anyValue[0].bytes), anyValueStringValue)
+	}
+	return result
+}
+
+type otlpPoint struct {
+	attributes map[string]string
+	start      uint64
+	time       uint64
+	value      float64
+	noValue    bool
+}
+
+type otlpMetric struct {
+	gauge      bool
+	cumulative bool
+	monotonic  bool
+	dataPoints []otlpPoint
+	resource   map[string]string
+	scopeName  string
+}
+
+// decodeOTLPMetrics decodes an ExportMetricsServiceRequest with a single
+// resource and scope into its metrics by name.
+func decodeOTLPMetrics(t *testing.T, b []byte) map[string]otlpMetric {
+	t.Helper()
+
+	resourceMetrics := protoFields(decodeProto(t, b), exportRequestResourceMetrics)
+	if len(resourceMetrics) != 1 {
+		t.Fatalf("expected one resource metrics: found=%d", len(resourceMetrics))
+	}
+	rmFields := decodeProto(t, resourceMetrics[0].bytes)
+	resource := decodeKeyValues(t, protoFields(
+		decodeProto(t, protoFields(rmFields, resourceMetricsResource)[0].bytes),
+		resourceAttributes))
+
+	scopeMetrics := protoFields(rmFields, resourceMetricsScopeMetrics)
+	if len(scopeMetrics) != 1 {
+		t.Fatalf("expected one scope metrics: found=%d", len(scopeMetrics))
+	}
+	smFields := decodeProto(t, scopeMetrics[0].bytes)
+	scopeName := protoString(t,
+		decodeProto(t, protoFields(smFields, scopeMetricsScope)[0].bytes), scopeName)
+
+	metrics := protoFields(smFields, scopeMetricsMetrics)
+	result := make(map[string]otlpMetric)
+	for _, m := range metrics {
+		mFields := decodeProto(t, m.bytes)
+		metric := otlpMetric{
+			resource:  resource,
+			scopeName: scopeName,
+		}
+		var data []protoField
+		if gauge := protoFields(mFields, metricGauge); len(gauge) == 1 {
+			metric.gauge = true
+			data = decodeProto(t, gauge[0].bytes)
+		} else if sum := protoFields(mFields, metricSum); len(sum) == 1 {
+			data = decodeProto(t, sum[0].bytes)
+			for _, f := range protoFields(data, sumAggregationTemporality) {
+				metric.cumulative = f.varint == aggregationTemporalityCumulative
+			}
+			for _, f := range protoFields(data, sumIsMonotonic) {
+				metric.monotonic = f.varint == 1
+			}
+		} else {
+			t.Fatalf("metric is neither a gauge nor a sum: fields=%v", mFields)
+		}
+
+		for _, p := range protoFields(data, gaugeDataPoints) {
+			pFields := decodeProto(t, p.bytes)
+			point := otlpPoint{
+				attributes: decodeKeyValues(t, protoFields(pFields, dataPointAttributes)),
+			}
+			for _, f := range protoFields(pFields, dataPointStartTimeUnixNano) {
+				point.start = f.fixed64
+			}
+			for _, f := range protoFields(pFields, dataPointTimeUnixNano) {
+				point.time = f.fixed64
+			}
+			for _, f := range protoFields(pFields, dataPointAsDouble) {
+				point.value = math.Float64frombits(f.fixed64)
+			}
+			for _, f := range protoFields(pFields, dataPointFlags) {
+				point.noValue = f.varint&dataPointNoValueFlag != 0
+			}
+			metric.dataPoints = append(metric.dataPoints, point)
+		}
+		result[protoString(t, mFields, metricName)] = metric
+	}
+	return result
+}
+
+func TestEncodeOTLPMetricsRoundTrip(t *testing.T) {
+	starts := newOTLPStartTimes()
+	resource := map[string]string{"service.name": "test", "region": "us"}
+	batch := []prompb.TimeSeries{
+		{
+			Labels: []prompb.Label{
+				{Name: labels.MetricName, Value: "cpu"},
+				{Name: "host", Value: "a"},
+			},
+			Samples: []prompb.Sample{
+				{Value: 1.5, Timestamp: 1000},
+				{Value: math.Float64frombits(value.StaleNaN), Timestamp: 2000},
+			},
+		},
+		{
+			Labels: []prompb.Label{
+				{Name: labels.MetricName, Value: "requests_total"},
+				{Name: "host", Value: "a"},
+			},
+			Samples: []prompb.Sample{{Value: 5, Timestamp: 2000}},
+		},
+	}
+
+	metrics := decodeOTLPMetrics(t, encodeOTLPMetrics(batch, resource, starts))
+	if len(metrics) != 2 {
+		t.Fatalf("unexpected metrics: metrics=%v", metrics)
+	}
+
+	cpu := metrics["cpu"]
+	if cpu.resource["service.name"] != "test" || cpu.resource["region"] != "us" {
+		t.Errorf("unexpected resource: resource=%v", cpu.resource)
+	}
+	if cpu.scopeName != userAgent {
+		t.Errorf("unexpected scope name: name=%s", cpu.scopeName)
+	}
+	if !cpu.gauge || len(cpu.dataPoints) != 2 {
+		t.Fatalf("unexpected cpu metric: metric=%+v", cpu)
+	}
+	if p := cpu.dataPoints[0]; p.attributes["host"] != "a" || len(p.attributes) != 1 ||
+		p.time != 1000*1e6 || p.value != 1.5 || p.noValue || p.start != 0 {
+		t.Errorf("unexpected cpu point: point=%+v", p)
+	}
+	if p := cpu.dataPoints[1]; p.time != 2000*1e6 || !p.noValue {
+		t.Errorf("stale marker not flagged with no value: point=%+v", p)
+	}
+
+	requests := metrics["requests_total"]
+	if requests.gauge || !requests.cumulative || !requests.monotonic ||
+		len(requests.dataPoints) != 1 {
+		t.Fatalf("unexpected requests metric: metric=%+v", requests)
+	}
+	if p := requests.dataPoints[0]; p.start != 2000*1e6 || p.time != 2000*1e6 || p.value != 5 {
+		t.Errorf("unexpected requests point: point=%+v", p)
+	}
+
+	// The start time of a cumulative sum stays that of its first sample
+	batch[1].Samples = []prompb.Sample{{Value: 8, Timestamp: 3000}}
+	metrics = decodeOTLPMetrics(t, encodeOTLPMetrics(batch[1:], resource, starts))
+	if p := metrics["requests_total"].dataPoints[0]; p.start != 2000*1e6 || p.time != 3000*1e6 {
+		t.Errorf("unexpected start time of a later point: point=%+v", p)
+	}
+}
@@ -0,0 +1,493 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sink"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	defaultConcurrency = 4
+	defaultBatchSize   = 1000
+	defaultTimeout     = 30 * time.Second
+
+	remoteWriteVersion = "0.1.0"
+	userAgent          = "high_cardinality_microbenchmark"
+)
+
+func init() {
+	sink.Register("remote_write", func(opts sink.Options) (sink.Sink, error) {
+		senderOpts, err := parseSinkOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return NewSender(senderOpts)
+	})
+}
+
+// parseSinkOptions parses the sink params common to every protocol.
+func parseSinkOptions(opts sink.Options) (Options, error) {
+	senderOpts := Options{URL: opts.Endpoint}
+	for name, value := range map[string]*int{
+		"concurrency": &senderOpts.Concurrency,
+		"batch_size":  &senderOpts.BatchSize,
+	} {
+		str, ok := opts.Params[name]
+		if !ok {
+			continue
+		}
+		v, err := strconv.Atoi(str)
+		if err != nil {
+			return Options{}, fmt.Errorf("invalid sink param: name=%s, value=%s, err=%v",
+				name, str, err)
+		}
+		*value = v
+	}
+	for name, value := range map[string]*time.Duration{
+		"timeout":        &senderOpts.Timeout,
+		"max_latency":    &senderOpts.Breaker.MaxLatency,
+		"breaker_window": &senderOpts.Breaker.Window,
+		"breaker_pause":  &senderOpts.Breaker.PauseFor,
+	} {
+		str, ok := opts.Params[name]
+		if !ok {
+			continue
+		}
+		v, err := time.ParseDuration(str)
+		if err != nil {
+			return Options{}, fmt.Errorf("invalid sink param: name=%s, value=%s, err=%v",
+				name, str, err)
+		}
+		*value = v
+	}
+	if str, ok := opts.Params["separate_families"]; ok {
+		v, err := strconv.ParseBool(str)
+		if err != nil {
+			return Options{}, fmt.Errorf("invalid sink param: name=separate_families, value=%s, err=%v",
+				str, err)
+		}
+		senderOpts.SeparateFamilies = v
+	}
+	if str, ok := opts.Params["stats_by"]; ok {
+		fn, err := ParseSourceFn(str, opts.SourceFn)
+		if err != nil {
+			return Options{}, fmt.Errorf("invalid sink param: name=stats_by, value=%s, err=%v",
+				str, err)
+		}
+		senderOpts.SourceFn = fn
+	}
+	if str, ok := opts.Params["max_error_rate"]; ok {
+		v, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return Options{}, fmt.Errorf("invalid sink param: name=max_error_rate, value=%s, err=%v",
+				str, err)
+		}
+		senderOpts.Breaker.MaxErrorRate = v
+	}
+	if opts.OnBreakerTrip != nil {
+		senderOpts.Breaker.OnTrip = func(event BreakerEvent) {
+			opts.OnBreakerTrip(event.String())
+		}
+	}
+	return senderOpts, nil
+}
+
+type Options struct {
+	// URL is the remote write endpoint, e.g.
+	// http://localhost:9090/api/v1/write.
+	URL string
+	// Concurrency is the number of requests in flight at a time.
+	Concurrency int
+	// BatchSize is the maximum number of series sent per request.
+	BatchSize int
+	// SeparateFamilies never mixes metric families in a request, for
+	// backends that require it. A histogram or summary's _bucket, _sum and
+	// _count series are one family.
+	SeparateFamilies bool
+	// Timeout bounds each request, including reading the response.
+	Timeout time.Duration
+	// Headers are added to every request, e.g. for auth or tenancy.
+	Headers map[string]string
+	// Breaker stops sending when the backend is failing.
+	Breaker BreakerOptions
+	// SourceFn when set attributes every series to a source, e.g. a
+	// component of a blended workload, to break Stats down by source.
+	SourceFn func(series prompb.TimeSeries) string
+	Client   *http.Client
+}
+
+// Stats are the totals sent since the sender was created.
+type Stats struct {
+	Requests int64
+	Failures int64
+	Series   int64
+	Samples  int64
+	// Bytes is the number of compressed request body bytes sent.
+	Bytes int64
+	// BreakerTrips is the number of times the circuit breaker tripped.
+	BreakerTrips int64
+	// Sources are the totals per source when Options.SourceFn is set.
+	Sources map[string]sink.SourceStats
+}
+
+// Sender pushes series to a Prometheus remote write endpoint as snappy
+// compressed protobuf, or to an OTLP endpoint when created with
+// NewOTLPSender.
+type Sender struct {
+	requests int64
+	failures int64
+	series   int64
+	samples  int64
+	bytes    int64
+
+	opts      Options
+	transport transport
+	breaker   *breaker
+	sources   *sourceStats
+}
+
+// transport encodes and sends batches for a protocol.
+type transport interface {
+	encode(batch []prompb.TimeSeries) ([]byte, error)
+	post(ctx context.Context, body []byte) error
+	close() error
+}
+
+var _ sink.StatsSink = (*Sender)(nil)
+
+func NewSender(opts Options) (*Sender, error) {
+	if err := validateHTTPURL("remote write", opts.URL); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+	return newSender(opts, &httpTransport{
+		protocol: "remote write",
+		url:      opts.URL,
+		client:   opts.httpClient(),
+		timeout:  opts.Timeout,
+		headers: map[string]string{
+			"Content-Encoding":                  "snappy",
+			"Content-Type":                      "application/x-protobuf",
+			"User-Agent":                        userAgent,
+			"X-Prometheus-Remote-Write-Version": remoteWriteVersion,
+		},
+		extraHeaders: opts.Headers,
+		encodeFn: func(batch []prompb.TimeSeries) ([]byte, error) {
+			req := prompb.WriteRequest{Timeseries: sortedLabels(batch)}
+			data, err := req.Marshal()
+			if err != nil {
+				return nil, fmt.Errorf("could not marshal write request: err=%v", err)
+			}
+			return snappy.Encode(nil, data), nil
+		},
+	}), nil
+}
+
+// sortedLabels returns the series with their labels sorted by name, as
+// remote write requires and receivers do not sort them. Simulators emit
+// labels in the order they build them, so labels are copied rather than
+// sorted in place.
+func sortedLabels(batch []prompb.TimeSeries) []prompb.TimeSeries {
+	result := make([]prompb.TimeSeries, 0, len(batch))
+	for _, series := range batch {
+		if !sort.SliceIsSorted(series.Labels, func(i, j int) bool {
+			return series.Labels[i].Name < series.Labels[j].Name
+		}) {
+			seriesLabels := append([]prompb.Label(nil), series.Labels...)
+			sort.Slice(seriesLabels, func(i, j int) bool {
+				return seriesLabels[i].Name < seriesLabels[j].Name
+			})
+			series.Labels = seriesLabels
+		}
+		result = append(result, series)
+	}
+	return result
+}
+
+func newSender(opts Options, t transport) *Sender {
+	var b *breaker
+	if opts.Breaker.enabled() {
+		b = newBreaker(opts.Breaker)
+	}
+	var sources *sourceStats
+	if opts.SourceFn != nil {
+		sources = newSourceStats(opts.SourceFn)
+	}
+	return &Sender{
+		opts:      opts,
+		transport: t,
+		breaker:   b,
+		sources:   sources,
+	}
+}
+
+func (o Options) withDefaults() Options {
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	return o
+}
+
+func (o Options) httpClient() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: o.Concurrency,
+		},
+	}
+}
+
+func validateHTTPURL(protocol, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid %s url: url=%s, err=%v",
+			protocol, rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s url must be http or https: url=%s",
+			protocol, rawURL)
+	}
+	return nil
+}
+
+// Write sends the series in batches of at most BatchSize series, with up to
+// Concurrency requests in flight, returning once all batches have been sent
+// or the first one has failed. With a circuit breaker failed batches are
+// only counted, leaving it to the breaker to stop sending, and Write fails
+// once it has aborted.
+func (s *Sender) Write(ctx context.Context, series []prompb.TimeSeries) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, s.opts.Concurrency)
+	)
+	setErr := func(err error) {
+		lock.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		lock.Unlock()
+	}
+
+	for _, batch := range s.batches(series) {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(batch []prompb.TimeSeries) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := s.send(ctx, batch); err != nil {
+				setErr(err)
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// batches splits the series into batches of at most BatchSize series, each
+// of a single metric family when SeparateFamilies is set.
+func (s *Sender) batches(series []prompb.TimeSeries) [][]prompb.TimeSeries {
+	groups := [][]prompb.TimeSeries{series}
+	if s.opts.SeparateFamilies {
+		groups = groups[:0]
+		byFamily := make(map[string]int)
+		for _, ts := range series {
+			family := metricFamily(ts)
+			i, ok := byFamily[family]
+			if !ok {
+				i = len(groups)
+				byFamily[family] = i
+				groups = append(groups, nil)
+			}
+			groups[i] = append(groups[i], ts)
+		}
+	}
+
+	var batches [][]prompb.TimeSeries
+	for _, group := range groups {
+		for start := 0; start < len(group); start += s.opts.BatchSize {
+			end := start + s.opts.BatchSize
+			if end > len(group) {
+				end = len(group)
+			}
+			batches = append(batches, group[start:end])
+		}
+	}
+	return batches
+}
+
+// metricFamily returns the metric name of the series without any histogram
+// or summary suffix.
+func metricFamily(series prompb.TimeSeries) string {
+	for _, l := range series.Labels {
+		if l.Name != labels.MetricName {
+			continue
+		}
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if strings.HasSuffix(l.Value, suffix) {
+				return strings.TrimSuffix(l.Value, suffix)
+			}
+		}
+		return l.Value
+	}
+	return ""
+}
+
+func (s *Sender) send(ctx context.Context, batch []prompb.TimeSeries) error {
+	body, err := s.transport.encode(batch)
+	if err != nil {
+		return err
+	}
+
+	samples := 0
+	for _, series := range batch {
+		samples += len(series.Samples)
+	}
+
+	if s.breaker != nil {
+		if err := s.breaker.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	atomic.AddInt64(&s.requests, 1)
+	start := time.Now()
+	err = s.transport.post(ctx, body)
+	if s.breaker != nil && ctx.Err() == nil {
+		s.breaker.record(time.Since(start), err != nil)
+	}
+	if s.sources != nil && ctx.Err() == nil {
+		s.sources.record(batch, err != nil)
+	}
+	if err != nil {
+		atomic.AddInt64(&s.failures, 1)
+		if s.breaker != nil && ctx.Err() == nil {
+			// The breaker decides when failures stop sending
+			return nil
+		}
+		return err
+	}
+	atomic.AddInt64(&s.series, int64(len(batch)))
+	atomic.AddInt64(&s.samples, int64(samples))
+	atomic.AddInt64(&s.bytes, int64(len(body)))
+	return nil
+}
+
+// httpTransport posts encoded batches to an HTTP endpoint.
+type httpTransport struct {
+	protocol     string
+	url          string
+	client       *http.Client
+	timeout      time.Duration
+	headers      map[string]string
+	extraHeaders map[string]string
+	encodeFn     func(batch []prompb.TimeSeries) ([]byte, error)
+}
+
+func (t *httpTransport) encode(batch []prompb.TimeSeries) ([]byte, error) {
+	return t.encodeFn(batch)
+}
+
+func (t *httpTransport) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	for name, value := range t.extraHeaders {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s failed: url=%s, status=%d, body=%s",
+			t.protocol, t.url, resp.StatusCode, msg)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+func (t *httpTransport) close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *Sender) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (s *Sender) Close() error {
+	return s.transport.close()
+}
+
+func (s *Sender) Stats() Stats {
+	stats := Stats{
+		Requests: atomic.LoadInt64(&s.requests),
+		Failures: atomic.LoadInt64(&s.failures),
+		Series:   atomic.LoadInt64(&s.series),
+		Samples:  atomic.LoadInt64(&s.samples),
+		Bytes:    atomic.LoadInt64(&s.bytes),
+	}
+	if s.breaker != nil {
+		stats.BreakerTrips = s.breaker.tripCount()
+	}
+	if s.sources != nil {
+		stats.Sources = s.sources.snapshot()
+	}
+	return stats
+}
@@ -0,0 +1,127 @@
+package sender
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sink"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// SourceByFamily attributes series to their metric family.
+	SourceByFamily = "family"
+	// SourceByComponent attributes series with the sink.Options SourceFn,
+	// e.g. to the blend component that generated them.
+	SourceByComponent = "component"
+	// sourceByLabelPrefix attributes series to the value of the named
+	// label, e.g. "label:job".
+	sourceByLabelPrefix = "label:"
+)
+
+// sourceStats accumulates sink.SourceStats per source. A failed request
+// counts against every source in it.
+type sourceStats struct {
+	sync.Mutex
+	sourceFn func(series prompb.TimeSeries) string
+	sources  map[string]*sink.SourceStats
+}
+
+// ParseSourceFn parses how series are attributed to a source, either
+// SourceByFamily, SourceByComponent using componentFn or "label:<name>".
+func ParseSourceFn(
+	by string,
+	componentFn func(series prompb.TimeSeries) string,
+) (func(series prompb.TimeSeries) string, error) {
+	switch by {
+	case SourceByFamily:
+		return metricFamily, nil
+	case SourceByComponent:
+		if componentFn == nil {
+			return nil, fmt.Errorf("no component source for the sink: value=%s", by)
+		}
+		return componentFn, nil
+	}
+	if strings.HasPrefix(by, sourceByLabelPrefix) {
+		name := strings.TrimPrefix(by, sourceByLabelPrefix)
+		if name == "" {
+			return nil, fmt.Errorf("source label name is empty: value=%s", by)
+		}
+		return func(series prompb.TimeSeries) string {
+			for _, l := range series.Labels {
+				if l.Name == name {
+					return l.Value
+				}
+			}
+			return ""
+		}, nil
+	}
+	return nil, fmt.Errorf("source must be %s, %s or %s<name>: value=%s",
+		SourceByFamily, SourceByComponent, sourceByLabelPrefix, by)
+}
+
+func newSourceStats(sourceFn func(series prompb.TimeSeries) string) *sourceStats {
+	return &sourceStats{
+		sourceFn: sourceFn,
+		sources:  make(map[string]*sink.SourceStats),
+	}
+}
+
+func (s *sourceStats) record(batch []prompb.TimeSeries, failed bool) {
+	batchStats := make(map[string]*sink.SourceStats)
+	for _, series := range batch {
+		source := s.sourceFn(series)
+		stats, ok := batchStats[source]
+		if !ok {
+			stats = &sink.SourceStats{}
+			batchStats[source] = stats
+		}
+		if failed {
+			stats.FailedSeries++
+			stats.FailedSamples += int64(len(series.Samples))
+		} else {
+			stats.Series++
+			stats.Samples += int64(len(series.Samples))
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for source, batch := range batchStats {
+		stats, ok := s.sources[source]
+		if !ok {
+			stats = &sink.SourceStats{}
+			s.sources[source] = stats
+		}
+		stats.Series += batch.Series
+		stats.Samples += batch.Samples
+		stats.FailedSeries += batch.FailedSeries
+		stats.FailedSamples += batch.FailedSamples
+		if failed {
+			stats.FailedRequests++
+		}
+	}
+}
+
+func (s *sourceStats) snapshot() map[string]sink.SourceStats {
+	s.Lock()
+	defer s.Unlock()
+
+	result := make(map[string]sink.SourceStats, len(s.sources))
+	for source, stats := range s.sources {
+		result[source] = *stats
+	}
+	return result
+}
+
+// SourceStats returns the stats per source so far, empty unless the
+// stats_by sink param is set.
+func (s *Sender) SourceStats() map[string]sink.SourceStats {
+	if s.sources == nil {
+		return map[string]sink.SourceStats{}
+	}
+	return s.sources.snapshot()
+}
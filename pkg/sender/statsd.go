@@ -0,0 +1,163 @@
+package sender
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sink"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// StatsDFlavorDogStatsD sends labels as DogStatsD tags, e.g.
+	// cpu:48.7|g|#hostname:host_0,measurement:usage_user.
+	StatsDFlavorDogStatsD = "dogstatsd"
+	// StatsDFlavorStatsD folds label values into the name as plain StatsD
+	// has no tags, e.g. cpu.host_0.usage_user:48.7|g.
+	StatsDFlavorStatsD = "statsd"
+
+	// defaultStatsDPacketSize keeps datagrams within an Ethernet MTU.
+	defaultStatsDPacketSize = 1432
+)
+
+var (
+	statsdNameEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", " ", "_", "\n", "_")
+	statsdTagEscaper  = strings.NewReplacer(":", "_", "|", "_", ",", "_", "@", "_", "#", "_", " ", "_", "\n", "_")
+	statsdPathEscaper = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", "#", "_", " ", "_", "\n", "_")
+)
+
+func init() {
+	sink.Register("statsd", func(opts sink.Options) (sink.Sink, error) {
+		senderOpts, err := parseSinkOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		statsdOpts := StatsDOptions{
+			Options: senderOpts,
+			Flavor:  opts.Params["flavor"],
+		}
+		if str, ok := opts.Params["packet_size"]; ok {
+			v, err := strconv.Atoi(str)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sink param: name=packet_size, value=%s, err=%v",
+					str, err)
+			}
+			statsdOpts.PacketSize = v
+		}
+		return NewStatsDSender(statsdOpts)
+	})
+}
+
+type StatsDOptions struct {
+	// Options configure batching, concurrency and the breaker as for remote
+	// write. URL is the host:port of the StatsD UDP listener, e.g.
+	// localhost:8125.
+	Options
+	// Flavor is StatsDFlavorDogStatsD, the default, or StatsDFlavorStatsD.
+	Flavor string
+	// PacketSize is the maximum size of each datagram.
+	PacketSize int
+}
+
+// NewStatsDSender returns a sender that sends every sample as a StatsD
+// gauge over UDP, several per datagram. Counters are sent as gauges too as
+// the simulators produce cumulative values rather than StatsD deltas, and
+// timestamps are dropped as StatsD has none. NaN and infinite samples,
+// including staleness markers, are dropped.
+func NewStatsDSender(opts StatsDOptions) (*Sender, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("no statsd address")
+	}
+	if opts.Flavor == "" {
+		opts.Flavor = StatsDFlavorDogStatsD
+	}
+	if opts.Flavor != StatsDFlavorDogStatsD && opts.Flavor != StatsDFlavorStatsD {
+		return nil, fmt.Errorf("unknown statsd flavor: flavor=%s, supported=%v",
+			opts.Flavor, []string{StatsDFlavorDogStatsD, StatsDFlavorStatsD})
+	}
+	if opts.PacketSize <= 0 {
+		opts.PacketSize = defaultStatsDPacketSize
+	}
+	opts.Options = opts.Options.withDefaults()
+
+	tags := opts.Flavor == StatsDFlavorDogStatsD
+	return newSender(opts.Options, &connTransport{
+		protocol:   "statsd",
+		network:    "udp",
+		addr:       opts.URL,
+		timeout:    opts.Timeout,
+		packetSize: opts.PacketSize,
+		encodeFn: func(batch []prompb.TimeSeries) ([]byte, error) {
+			return encodeStatsDLines(batch, tags), nil
+		},
+	}), nil
+}
+
+func encodeStatsDLines(batch []prompb.TimeSeries, withTags bool) []byte {
+	var (
+		buf  bytes.Buffer
+		tags []prompb.Label
+	)
+	for _, series := range batch {
+		name := ""
+		tags = tags[:0]
+		for _, l := range series.Labels {
+			if l.Name == labels.MetricName {
+				name = l.Value
+			} else if l.Value != "" {
+				tags = append(tags, l)
+			}
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			return tags[i].Name < tags[j].Name
+		})
+
+		var metric, suffix strings.Builder
+		metric.WriteString(statsdNameEscaper.Replace(name))
+		if withTags {
+			for i, tag := range tags {
+				if i == 0 {
+					suffix.WriteString("|#")
+				} else {
+					suffix.WriteByte(',')
+				}
+				suffix.WriteString(statsdTagEscaper.Replace(tag.Name))
+				suffix.WriteByte(':')
+				suffix.WriteString(statsdTagEscaper.Replace(tag.Value))
+			}
+		} else {
+			for _, tag := range tags {
+				metric.WriteByte('.')
+				metric.WriteString(statsdPathEscaper.Replace(tag.Value))
+			}
+		}
+
+		for _, sample := range series.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			if sample.Value < 0 {
+				// A signed gauge value is a relative change, so reset to zero
+				// before decrementing to the negative value
+				writeStatsDGauge(&buf, metric.String(), 0, suffix.String())
+			}
+			writeStatsDGauge(&buf, metric.String(), sample.Value, suffix.String())
+		}
+	}
+	return buf.Bytes()
+}
+
+func writeStatsDGauge(buf *bytes.Buffer, metric string, v float64, suffix string) {
+	buf.WriteString(metric)
+	buf.WriteByte(':')
+	buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	buf.WriteString("|g")
+	buf.WriteString(suffix)
+	buf.WriteByte('\n')
+}
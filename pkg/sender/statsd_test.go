@@ -0,0 +1,31 @@
+package sender
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// newlineSeries has a newline in its name, a label name and a label value,
+// which would otherwise split its line in line based protocols.
+func newlineSeries() []prompb.TimeSeries {
+	return []prompb.TimeSeries{{
+		Labels: []prompb.Label{
+			{Name: labels.MetricName, Value: "cpu\nusage"},
+			{Name: "host\nname", Value: "a\nb"},
+		},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+}
+
+func TestEncodeStatsDLinesEscapesNewlines(t *testing.T) {
+	for withTags, expected := range map[bool]string{
+		true:  "cpu_usage:1|g|#host_name:a_b\n",
+		false: "cpu_usage.a_b:1|g\n",
+	} {
+		if lines := string(encodeStatsDLines(newlineSeries(), withTags)); lines != expected {
+			t.Errorf("unexpected lines: tags=%v, expected=%q, actual=%q", withTags, expected, lines)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/generator"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	defaultBackfillScrapeInterval = 10 * time.Second
+	defaultBackfillBatchSize      = 10000
+)
+
+type BackfillOptions struct {
+	// Start and End are the time range replayed, End is exclusive.
+	Start time.Time
+	End   time.Time
+	// ScrapeInterval is the simulated interval between samples of every
+	// series, defaults to 10s.
+	ScrapeInterval time.Duration
+	// NewSeriesPercent is the fraction [0.0,1.0] of series churned per
+	// scrape interval.
+	NewSeriesPercent float64
+	// BatchSize is the maximum number of series per write, defaults to
+	// 10000. Batches do not span scrape intervals.
+	BatchSize int
+}
+
+type BackfillStats struct {
+	Scrapes int64
+	Series  int64
+	Samples int64
+	Took    time.Duration
+}
+
+// Backfill replays the simulator over a past time range as fast as the
+// sink accepts writes, setting the clock, which must be the simulator's
+// TimeNowFn, to every scrape interval in turn and generating a full pass
+// over all targets at each. Samples are therefore written in time order,
+// with historical timestamps, for benchmarking backfill and out-of-order
+// ingestion paths. The sink is flushed after every scrape interval, so
+// stages that combine a round's series write them before the next round.
+func Backfill(
+	ctx context.Context,
+	sim generator.Simulator,
+	clock *generator.VirtualClock,
+	s Sink,
+	opts BackfillOptions,
+) (BackfillStats, error) {
+	if !opts.End.After(opts.Start) {
+		return BackfillStats{}, fmt.Errorf("backfill end not after start: start=%v, end=%v",
+			opts.Start, opts.End)
+	}
+	interval := opts.ScrapeInterval
+	if interval <= 0 {
+		interval = defaultBackfillScrapeInterval
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBackfillBatchSize
+	}
+
+	var (
+		stats   BackfillStats
+		started = time.Now()
+		batch   = make([]prompb.TimeSeries, 0, batchSize)
+		write   = func() error {
+			if err := s.Write(ctx, batch); err != nil {
+				return err
+			}
+			batch = make([]prompb.TimeSeries, 0, batchSize)
+			return nil
+		}
+	)
+	for t := opts.Start; t.Before(opts.End); t = t.Add(interval) {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		clock.Set(t)
+		err := sim.GenerateStream(interval, interval, opts.NewSeriesPercent,
+			func(series prompb.TimeSeries) error {
+				stats.Series++
+				stats.Samples += int64(len(series.Samples))
+				batch = append(batch, series)
+				if len(batch) < batchSize {
+					return nil
+				}
+				return write()
+			})
+		if err != nil {
+			return stats, fmt.Errorf("could not backfill: time=%v, err=%v", t, err)
+		}
+		if len(batch) > 0 {
+			if err := write(); err != nil {
+				return stats, fmt.Errorf("could not backfill: time=%v, err=%v", t, err)
+			}
+		}
+		if err := s.Flush(ctx); err != nil {
+			return stats, fmt.Errorf("could not backfill: time=%v, err=%v", t, err)
+		}
+		stats.Scrapes++
+	}
+	stats.Took = time.Since(started)
+	return stats, nil
+}
@@ -0,0 +1,120 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/generator"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// recordingSink records the writes made to it and the number of series
+// written before each flush.
+type recordingSink struct {
+	writes  [][]prompb.TimeSeries
+	written int
+	flushes []int
+}
+
+func (s *recordingSink) Write(ctx context.Context, batch []prompb.TimeSeries) error {
+	s.writes = append(s.writes, batch)
+	s.written += len(batch)
+	return nil
+}
+
+func (s *recordingSink) Flush(ctx context.Context) error {
+	s.flushes = append(s.flushes, s.written)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func TestBackfillWritesScrapesInTimeOrder(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := generator.NewVirtualClock(start)
+	sim, err := generator.NewSimulator("hosts", generator.SimulatorOptions{
+		Targets:   2,
+		Start:     start,
+		TimeNowFn: clock.Now,
+		Seed:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := sim.ActiveSeries()
+
+	s := &recordingSink{}
+	stats, err := Backfill(context.Background(), sim, clock, s, BackfillOptions{
+		Start:          start,
+		End:            start.Add(time.Minute),
+		ScrapeInterval: 10 * time.Second,
+		BatchSize:      150,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Scrapes != 6 || stats.Series != int64(6*active) || stats.Samples != stats.Series {
+		t.Errorf("unexpected stats: stats=%+v, active=%d", stats, active)
+	}
+
+	last := int64(0)
+	for _, batch := range s.writes {
+		if len(batch) > 150 {
+			t.Errorf("batch over the batch size: series=%d", len(batch))
+		}
+		ts := batch[0].Samples[0].Timestamp
+		for _, series := range batch {
+			if series.Samples[0].Timestamp != ts {
+				t.Fatalf("batch spans scrape intervals: timestamps=%d,%d",
+					ts, series.Samples[0].Timestamp)
+			}
+		}
+		if ts < last {
+			t.Errorf("writes not in time order: timestamp=%d, last=%d", ts, last)
+		}
+		last = ts
+	}
+	if expected := start.Add(50*time.Second).UnixNano() / int64(time.Millisecond); last != expected {
+		t.Errorf("unexpected last timestamp: timestamp=%d, expected=%d", last, expected)
+	}
+
+	// The sink is flushed after every scrape interval's series
+	if len(s.flushes) != 6 {
+		t.Fatalf("expected a flush per scrape interval: flushes=%v", s.flushes)
+	}
+	for i, written := range s.flushes {
+		if written != (i+1)*active {
+			t.Errorf("flush not after a full scrape interval: flush=%d, written=%d", i, written)
+		}
+	}
+}
+
+func TestBackfillRejectsEmptyRange(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := generator.NewVirtualClock(start)
+	_, err := Backfill(context.Background(), nil, clock, &recordingSink{}, BackfillOptions{
+		Start: start,
+		End:   start,
+	})
+	if err == nil {
+		t.Error("expected an error for an end not after the start")
+	}
+}
+
+func TestBackfillStopsWhenCanceled(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := generator.NewVirtualClock(start)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Backfill(ctx, nil, clock, &recordingSink{}, BackfillOptions{
+		Start: start,
+		End:   start.Add(time.Hour),
+	})
+	if err != context.Canceled {
+		t.Errorf("expected the context's error: err=%v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package sink
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func init() {
+	Register("discard", func(opts Options) (Sink, error) {
+		return NewDiscardSink(), nil
+	})
+}
+
+// DiscardSink drops everything written to it, useful to measure generation
+// cost on its own.
+type DiscardSink struct {
+	series  int64
+	samples int64
+}
+
+func NewDiscardSink() *DiscardSink {
+	return &DiscardSink{}
+}
+
+func (s *DiscardSink) Write(ctx context.Context, batch []prompb.TimeSeries) error {
+	samples := 0
+	for _, series := range batch {
+		samples += len(series.Samples)
+	}
+	atomic.AddInt64(&s.series, int64(len(batch)))
+	atomic.AddInt64(&s.samples, int64(samples))
+	return nil
+}
+
+func (s *DiscardSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (s *DiscardSink) Close() error {
+	return nil
+}
+
+// Written returns the number of series and samples written.
+func (s *DiscardSink) Written() (series, samples int64) {
+	return atomic.LoadInt64(&s.series), atomic.LoadInt64(&s.samples)
+}
@@ -0,0 +1,74 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/exposition"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func init() {
+	Register("openmetrics", func(opts Options) (Sink, error) {
+		return NewOpenMetricsFileSink(opts.Endpoint)
+	})
+}
+
+// OpenMetricsFileSink writes series with timestamps to a file in the
+// OpenMetrics format accepted by promtool tsdb create-blocks-from
+// openmetrics, to backfill stock Prometheus.
+type OpenMetricsFileSink struct {
+	sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func NewOpenMetricsFileSink(path string) (*OpenMetricsFileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no openmetrics output file path")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create openmetrics file: path=%s, err=%v",
+			path, err)
+	}
+	return &OpenMetricsFileSink{
+		file:   file,
+		writer: bufio.NewWriterSize(file, 1<<20),
+	}, nil
+}
+
+func (s *OpenMetricsFileSink) Write(ctx context.Context, batch []prompb.TimeSeries) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return exposition.WriteOpenMetrics(s.writer, batch, true)
+}
+
+func (s *OpenMetricsFileSink) Flush(ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.writer.Flush()
+}
+
+// Close terminates the exposition and closes the file, the file is not a
+// valid backfill input until closed.
+func (s *OpenMetricsFileSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := exposition.WriteOpenMetricsEOF(s.writer); err != nil {
+		s.file.Close()
+		return err
+	}
+	if err := s.writer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestOpenMetricsFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openmetrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backfill.om")
+	s, err := NewOpenMetricsFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for ts := int64(1000); ts <= 2000; ts += 1000 {
+		err := s.Write(ctx, []prompb.TimeSeries{{
+			Labels: []prompb.Label{
+				{Name: labels.MetricName, Value: "cpu"},
+				{Name: "host", Value: "a"},
+			},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: ts}},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "cpu{host=\"a\"} 1 1.000\ncpu{host=\"a\"} 1 2.000\n# EOF\n"
+	if string(data) != expected {
+		t.Errorf("unexpected file:\nexpected=%q\nactual=%q", expected, data)
+	}
+}
+
+func TestDiscardSinkCountsWritten(t *testing.T) {
+	s := NewDiscardSink()
+	err := s.Write(context.Background(), []prompb.TimeSeries{
+		{Samples: []prompb.Sample{{Value: 1}, {Value: 2}}},
+		{Samples: []prompb.Sample{{Value: 3}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series, samples := s.Written(); series != 2 || samples != 3 {
+		t.Errorf("unexpected written: series=%d, samples=%d", series, samples)
+	}
+}
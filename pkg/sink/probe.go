@@ -0,0 +1,118 @@
+package sink
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	defaultTimestampProbeMetric = "timestamp_probe"
+	defaultTimestampProbeSeries = 1
+)
+
+// defaultTimestampProbeOffsets span the out-of-window policies of common
+// backends, from a week in the past to a day in the future.
+var defaultTimestampProbeOffsets = []time.Duration{
+	-7 * 24 * time.Hour,
+	-3 * 24 * time.Hour,
+	-24 * time.Hour,
+	-6 * time.Hour,
+	-2 * time.Hour,
+	-time.Hour,
+	-10 * time.Minute,
+	0,
+	10 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+type TimestampProbeOptions struct {
+	// Offsets from the time of the probe to write samples at, defaults to
+	// a week in the past to a day in the future.
+	Offsets []time.Duration
+	// Series is the number of series written per offset, defaults to one.
+	Series int
+	// MetricName defaults to timestamp_probe.
+	MetricName string
+	TimeNowFn  func() time.Time
+}
+
+// TimestampProbeResult is the outcome of writing samples at an offset.
+type TimestampProbeResult struct {
+	Offset   time.Duration
+	Accepted bool
+	// Err is the write or flush error when not accepted.
+	Err error
+}
+
+// ProbeTimestamps writes a few samples at each offset from now and records
+// whether the sink accepted them, to map a backend's out-of-window policy
+// empirically. Every offset is written to its own series, labeled with the
+// offset and the probe's start so that neither out-of-order rejection nor
+// an earlier probe affect the outcome, and flushed before the next.
+// Sinks that cannot observe rejection, e.g. over UDP, accept everything.
+// It returns early only if ctx is done.
+func ProbeTimestamps(
+	ctx context.Context,
+	s Sink,
+	opts TimestampProbeOptions,
+) ([]TimestampProbeResult, error) {
+	offsets := opts.Offsets
+	if len(offsets) == 0 {
+		offsets = defaultTimestampProbeOffsets
+	}
+	seriesPerOffset := opts.Series
+	if seriesPerOffset <= 0 {
+		seriesPerOffset = defaultTimestampProbeSeries
+	}
+	metricName := opts.MetricName
+	if metricName == "" {
+		metricName = defaultTimestampProbeMetric
+	}
+	timeNowFn := time.Now
+	if opts.TimeNowFn != nil {
+		timeNowFn = opts.TimeNowFn
+	}
+
+	probe := strconv.FormatInt(timeNowFn().UnixNano()/int64(time.Millisecond), 10)
+	results := make([]TimestampProbeResult, 0, len(offsets))
+	for _, offset := range offsets {
+		now := timeNowFn()
+		timestamp := now.Add(offset).UnixNano() / int64(time.Millisecond)
+		batch := make([]prompb.TimeSeries, 0, seriesPerOffset)
+		for i := 0; i < seriesPerOffset; i++ {
+			batch = append(batch, prompb.TimeSeries{
+				Labels: []prompb.Label{
+					{Name: labels.MetricName, Value: metricName},
+					{Name: "offset", Value: offset.String()},
+					{Name: "probe", Value: probe},
+					{Name: "series", Value: strconv.Itoa(i)},
+				},
+				Samples: []prompb.Sample{{
+					Value:     float64(offset / time.Second),
+					Timestamp: timestamp,
+				}},
+			})
+		}
+
+		err := s.Write(ctx, batch)
+		if err == nil {
+			err = s.Flush(ctx)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return results, ctxErr
+		}
+		results = append(results, TimestampProbeResult{
+			Offset:   offset,
+			Accepted: err == nil,
+			Err:      err,
+		})
+	}
+	return results, nil
+}
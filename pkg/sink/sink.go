@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Sink is an output protocol generated series are written to.
+type Sink interface {
+	Write(ctx context.Context, batch []prompb.TimeSeries) error
+	// Flush writes out anything buffered by previous writes.
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+type Options struct {
+	// Endpoint is the address or path written to, its format is up to the
+	// sink.
+	Endpoint string
+	// Params are sink specific parameters.
+	Params map[string]string
+	// SourceFn when set attributes series to a source for sinks that
+	// support the stats_by=component param, e.g. BlendSimulator.Source.
+	SourceFn func(series prompb.TimeSeries) string
+	// OnBreakerTrip when set is called with the reason each time the
+	// circuit breaker of sinks that have one trips.
+	OnBreakerTrip func(reason string)
+}
+
+type NewSinkFn func(opts Options) (Sink, error)
+
+var (
+	sinksLock sync.RWMutex
+	sinks     = make(map[string]NewSinkFn)
+)
+
+// Register registers a sink so it can be constructed by name, it is
+// typically called from the init function of the sink's package.
+func Register(name string, fn NewSinkFn) {
+	sinksLock.Lock()
+	defer sinksLock.Unlock()
+
+	if _, ok := sinks[name]; ok {
+		panic(fmt.Sprintf("sink already registered: name=%s", name))
+	}
+	sinks[name] = fn
+}
+
+func New(name string, opts Options) (Sink, error) {
+	sinksLock.RLock()
+	fn, ok := sinks[name]
+	sinksLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown sink: name=%s, registered=%v",
+			name, Registered())
+	}
+	return fn(opts)
+}
+
+func Registered() []string {
+	sinksLock.RLock()
+	defer sinksLock.RUnlock()
+
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
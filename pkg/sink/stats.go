@@ -0,0 +1,32 @@
+package sink
+
+import (
+	"time"
+)
+
+// SourceStats are the totals a sink attributes to a source of series, e.g.
+// a component of a blended workload.
+type SourceStats struct {
+	Series         int64
+	Samples        int64
+	FailedSeries   int64
+	FailedSamples  int64
+	FailedRequests int64
+}
+
+// SamplesPerSecond returns the rate samples were written at over the given
+// duration.
+func (s SourceStats) SamplesPerSecond(took time.Duration) float64 {
+	if took <= 0 {
+		return 0
+	}
+	return float64(s.Samples) / took.Seconds()
+}
+
+// StatsSink is a Sink that attributes what it writes to sources.
+type StatsSink interface {
+	Sink
+	// SourceStats returns the totals per source since the sink was
+	// created, empty unless attribution is configured.
+	SourceStats() map[string]SourceStats
+}
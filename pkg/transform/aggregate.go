@@ -0,0 +1,208 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type AggregationType int
+
+const (
+	AggregationSum AggregationType = iota
+	AggregationCount
+	AggregationMin
+	AggregationMax
+)
+
+type AggregatorOptions struct {
+	// DropLabels are the label names rolled up, i.e. removed from each
+	// series before series with otherwise identical labels are combined.
+	DropLabels []string
+	Type       AggregationType
+	// KeepRaw also returns the raw series alongside the aggregated ones,
+	// so both streams can be written.
+	KeepRaw bool
+}
+
+// Aggregator emulates streaming aggregation in front of a backend by rolling
+// up series over dropped labels before they are written. Series are
+// combined across every Add until Flush, so that a group split over several
+// writes of a round is still written once per timestamp.
+type Aggregator struct {
+	sync.Mutex
+	dropLabels map[string]struct{}
+	aggType    AggregationType
+	keepRaw    bool
+	groups     map[string]*aggregatedSeries
+	keys       []string
+	seriesIn   map[uint64]struct{}
+	seriesOut  map[uint64]struct{}
+	samplesIn  int64
+	samplesOut int64
+}
+
+// AggregatorStats count distinct series by identity, so the cardinality
+// reduction is SeriesIn over SeriesOut.
+type AggregatorStats struct {
+	SeriesIn   int
+	SamplesIn  int64
+	SeriesOut  int
+	SamplesOut int64
+}
+
+func NewAggregator(opts AggregatorOptions) (*Aggregator, error) {
+	if len(opts.DropLabels) == 0 {
+		return nil, fmt.Errorf("no labels to aggregate away")
+	}
+	switch opts.Type {
+	case AggregationSum, AggregationCount, AggregationMin, AggregationMax:
+	default:
+		return nil, fmt.Errorf("unknown aggregation type: value=%v", opts.Type)
+	}
+
+	dropLabels := make(map[string]struct{}, len(opts.DropLabels))
+	for _, name := range opts.DropLabels {
+		dropLabels[name] = struct{}{}
+	}
+	return &Aggregator{
+		dropLabels: dropLabels,
+		aggType:    opts.Type,
+		keepRaw:    opts.KeepRaw,
+		groups:     make(map[string]*aggregatedSeries),
+		seriesIn:   make(map[uint64]struct{}),
+		seriesOut:  make(map[uint64]struct{}),
+	}, nil
+}
+
+type aggregatedSeries struct {
+	labels  []prompb.Label
+	samples map[int64]float64
+}
+
+// Add combines the series into their groups, returning the raw series if
+// configured to keep them and none otherwise. Staleness markers are not
+// aggregated.
+func (a *Aggregator) Add(series []prompb.TimeSeries) []prompb.TimeSeries {
+	a.Lock()
+	defer a.Unlock()
+
+	var key strings.Builder
+	for _, s := range series {
+		a.seriesIn[fingerprint(s.Labels)] = struct{}{}
+		a.samplesIn += int64(len(s.Samples))
+
+		key.Reset()
+		labels := make([]prompb.Label, 0, len(s.Labels))
+		for _, l := range s.Labels {
+			if _, drop := a.dropLabels[l.Name]; drop {
+				continue
+			}
+			labels = append(labels, l)
+			key.WriteString(l.Name)
+			key.WriteByte(0xff)
+			key.WriteString(l.Value)
+			key.WriteByte(0xff)
+		}
+
+		group, ok := a.groups[key.String()]
+		if !ok {
+			group = &aggregatedSeries{
+				labels:  labels,
+				samples: make(map[int64]float64),
+			}
+			a.groups[key.String()] = group
+			a.keys = append(a.keys, key.String())
+		}
+		for _, sample := range s.Samples {
+			if value.IsStaleNaN(sample.Value) {
+				continue
+			}
+			current, ok := group.samples[sample.Timestamp]
+			group.samples[sample.Timestamp] = a.combine(current, ok, sample.Value)
+		}
+	}
+
+	if !a.keepRaw {
+		return nil
+	}
+	return series
+}
+
+// Flush returns the aggregated series of everything added since the last
+// Flush and starts new groups.
+func (a *Aggregator) Flush() []prompb.TimeSeries {
+	a.Lock()
+	defer a.Unlock()
+
+	result := make([]prompb.TimeSeries, 0, len(a.keys))
+	for _, k := range a.keys {
+		group := a.groups[k]
+		if len(group.samples) == 0 {
+			continue
+		}
+		samples := make([]prompb.Sample, 0, len(group.samples))
+		for ts, v := range group.samples {
+			samples = append(samples, prompb.Sample{Timestamp: ts, Value: v})
+		}
+		sort.Slice(samples, func(i, j int) bool {
+			return samples[i].Timestamp < samples[j].Timestamp
+		})
+		result = append(result, prompb.TimeSeries{
+			Labels:  group.labels,
+			Samples: samples,
+		})
+		a.seriesOut[fingerprint(group.labels)] = struct{}{}
+		a.samplesOut += int64(len(samples))
+	}
+	a.groups = make(map[string]*aggregatedSeries)
+	a.keys = nil
+	return result
+}
+
+func (a *Aggregator) combine(current float64, exists bool, v float64) float64 {
+	switch a.aggType {
+	case AggregationCount:
+		return current + 1
+	case AggregationMin:
+		if !exists {
+			return v
+		}
+		return math.Min(current, v)
+	case AggregationMax:
+		if !exists {
+			return v
+		}
+		return math.Max(current, v)
+	default:
+		return current + v
+	}
+}
+
+func (a *Aggregator) Stats() AggregatorStats {
+	a.Lock()
+	defer a.Unlock()
+
+	return AggregatorStats{
+		SeriesIn:   len(a.seriesIn),
+		SamplesIn:  a.samplesIn,
+		SeriesOut:  len(a.seriesOut),
+		SamplesOut: a.samplesOut,
+	}
+}
+
+// fingerprint identifies a series the way the Sampler does, by the hash of
+// its sorted labels.
+func fingerprint(seriesLabels []prompb.Label) uint64 {
+	lset := make(labels.Labels, 0, len(seriesLabels))
+	for _, l := range seriesLabels {
+		lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	return labels.New(lset...).Hash()
+}
@@ -0,0 +1,129 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func testSeries(name, host string, samples ...prompb.Sample) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: labels.MetricName, Value: name},
+			{Name: "host", Value: host},
+		},
+		Samples: samples,
+	}
+}
+
+func TestAggregatorCombinesGroupsAcrossBatches(t *testing.T) {
+	agg, err := NewAggregator(AggregatorOptions{
+		DropLabels: []string{"host"},
+		Type:       AggregationSum,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One round split over two writes, with the same group in both
+	batches := [][]prompb.TimeSeries{
+		{
+			testSeries("cpu", "a", prompb.Sample{Timestamp: 1000, Value: 1}),
+			testSeries("mem", "a", prompb.Sample{Timestamp: 1000, Value: 10}),
+		},
+		{
+			testSeries("cpu", "b", prompb.Sample{Timestamp: 1000, Value: 2}),
+			testSeries("cpu", "c", prompb.Sample{Timestamp: 1000, Value: 4}),
+		},
+	}
+	for _, batch := range batches {
+		if raw := agg.Add(batch); len(raw) != 0 {
+			t.Fatalf("raw series returned without keepRaw: series=%v", raw)
+		}
+	}
+
+	result := agg.Flush()
+	if len(result) != 2 {
+		t.Fatalf("expected one series per group: series=%v", result)
+	}
+	values := make(map[string][]prompb.Sample)
+	for _, s := range result {
+		if len(s.Labels) != 1 || s.Labels[0].Name != labels.MetricName {
+			t.Errorf("dropped label not rolled up: labels=%v", s.Labels)
+		}
+		values[s.Labels[0].Value] = s.Samples
+	}
+	if s := values["cpu"]; len(s) != 1 || s[0].Timestamp != 1000 || s[0].Value != 7 {
+		t.Errorf("unexpected cpu aggregate: samples=%v", s)
+	}
+	if s := values["mem"]; len(s) != 1 || s[0].Value != 10 {
+		t.Errorf("unexpected mem aggregate: samples=%v", s)
+	}
+
+	if result := agg.Flush(); len(result) != 0 {
+		t.Errorf("groups not reset by flush: series=%v", result)
+	}
+}
+
+func TestAggregatorTypes(t *testing.T) {
+	tests := []struct {
+		aggType  AggregationType
+		expected float64
+	}{
+		{aggType: AggregationSum, expected: 9},
+		{aggType: AggregationCount, expected: 3},
+		{aggType: AggregationMin, expected: -1},
+		{aggType: AggregationMax, expected: 8},
+	}
+	for _, test := range tests {
+		agg, err := NewAggregator(AggregatorOptions{
+			DropLabels: []string{"host"},
+			Type:       test.aggType,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		agg.Add([]prompb.TimeSeries{
+			testSeries("cpu", "a", prompb.Sample{Timestamp: 1000, Value: 2}),
+			testSeries("cpu", "b", prompb.Sample{Timestamp: 1000, Value: -1}),
+			testSeries("cpu", "c", prompb.Sample{Timestamp: 1000, Value: 8}),
+		})
+		result := agg.Flush()
+		if len(result) != 1 || len(result[0].Samples) != 1 ||
+			result[0].Samples[0].Value != test.expected {
+			t.Errorf("unexpected aggregate: type=%v, expected=%v, series=%v",
+				test.aggType, test.expected, result)
+		}
+	}
+}
+
+func TestAggregatorStatsCountDistinctSeries(t *testing.T) {
+	agg, err := NewAggregator(AggregatorOptions{
+		DropLabels: []string{"host"},
+		KeepRaw:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same series over three rounds
+	for ts := int64(1000); ts <= 3000; ts += 1000 {
+		batch := []prompb.TimeSeries{
+			testSeries("cpu", "a", prompb.Sample{Timestamp: ts, Value: 1}),
+			testSeries("cpu", "b", prompb.Sample{Timestamp: ts, Value: 1}),
+		}
+		if raw := agg.Add(batch); len(raw) != len(batch) {
+			t.Fatalf("raw series not kept: series=%v", raw)
+		}
+		agg.Flush()
+	}
+
+	stats := agg.Stats()
+	if stats.SeriesIn != 2 || stats.SeriesOut != 1 {
+		t.Errorf("series not deduped by identity: stats=%+v", stats)
+	}
+	if stats.SamplesIn != 6 || stats.SamplesOut != 3 {
+		t.Errorf("unexpected sample counts: stats=%+v", stats)
+	}
+}
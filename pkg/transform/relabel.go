@@ -0,0 +1,88 @@
+package transform
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ParseRelabelConfigs parses a YAML list of metric_relabel_configs style
+// rules, applying the same defaults as Prometheus does.
+func ParseRelabelConfigs(data []byte) ([]*relabel.Config, error) {
+	var configs []*relabel.Config
+	if err := yaml.UnmarshalStrict(data, &configs); err != nil {
+		return nil, fmt.Errorf("unable to parse relabel configs: %v", err)
+	}
+	return configs, nil
+}
+
+type RelabelerStats struct {
+	SeriesIn       int64
+	SeriesDropped  int64
+	SamplesIn      int64
+	SamplesDropped int64
+}
+
+// Relabeler applies drop/keep (and any other relabel action) rules to series
+// before they are written, counting what was dropped.
+type Relabeler struct {
+	sync.Mutex
+	configs []*relabel.Config
+	stats   RelabelerStats
+}
+
+func NewRelabeler(configs []*relabel.Config) (*Relabeler, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no relabel configs")
+	}
+	return &Relabeler{configs: configs}, nil
+}
+
+func (r *Relabeler) Apply(series []prompb.TimeSeries) []prompb.TimeSeries {
+	var stats RelabelerStats
+	result := series[:0:0]
+	for _, s := range series {
+		stats.SeriesIn++
+		stats.SamplesIn += int64(len(s.Samples))
+
+		lset := make(labels.Labels, 0, len(s.Labels))
+		for _, l := range s.Labels {
+			lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+		}
+		lset = relabel.Process(labels.New(lset...), r.configs...)
+		if lset == nil {
+			stats.SeriesDropped++
+			stats.SamplesDropped += int64(len(s.Samples))
+			continue
+		}
+
+		relabeled := make([]prompb.Label, 0, len(lset))
+		for _, l := range lset {
+			relabeled = append(relabeled, prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		result = append(result, prompb.TimeSeries{
+			Labels:  relabeled,
+			Samples: s.Samples,
+		})
+	}
+
+	r.Lock()
+	r.stats.SeriesIn += stats.SeriesIn
+	r.stats.SeriesDropped += stats.SeriesDropped
+	r.stats.SamplesIn += stats.SamplesIn
+	r.stats.SamplesDropped += stats.SamplesDropped
+	r.Unlock()
+
+	return result
+}
+
+func (r *Relabeler) Stats() RelabelerStats {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.stats
+}
@@ -0,0 +1,61 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestRelabelerDropsAndRewrites(t *testing.T) {
+	configs, err := ParseRelabelConfigs([]byte(`
+- source_labels: [__name__]
+  regex: mem
+  action: drop
+- source_labels: [host]
+  target_label: instance
+- regex: host
+  action: labeldrop
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRelabeler(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := r.Apply([]prompb.TimeSeries{
+		testSeries("cpu", "a", prompb.Sample{Timestamp: 1000, Value: 1}),
+		testSeries("mem", "a", prompb.Sample{Timestamp: 1000, Value: 1},
+			prompb.Sample{Timestamp: 2000, Value: 1}),
+	})
+	if len(result) != 1 {
+		t.Fatalf("dropped series written: series=%v", result)
+	}
+	got := result[0].Labels
+	if len(got) != 2 || got[0].Name != "__name__" || got[0].Value != "cpu" ||
+		got[1].Name != "instance" || got[1].Value != "a" {
+		t.Errorf("unexpected relabeled labels: labels=%v", got)
+	}
+
+	stats := r.Stats()
+	if stats.SeriesIn != 2 || stats.SeriesDropped != 1 ||
+		stats.SamplesIn != 3 || stats.SamplesDropped != 2 {
+		t.Errorf("unexpected stats: stats=%+v", stats)
+	}
+}
+
+func TestParseRelabelConfigsErrors(t *testing.T) {
+	for _, data := range []string{
+		"- action: nonexistent",
+		"- unknown_field: x",
+		"not a list",
+	} {
+		if _, err := ParseRelabelConfigs([]byte(data)); err == nil {
+			t.Errorf("expected invalid relabel config error: data=%q", data)
+		}
+	}
+	if _, err := NewRelabeler(nil); err == nil {
+		t.Error("expected an error without relabel configs")
+	}
+}
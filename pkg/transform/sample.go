@@ -0,0 +1,137 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type SamplerOptions struct {
+	// KeepPercent is the fraction (0.0,1.0] of series kept.
+	KeepPercent float64
+	// TrackLabels are label names whose distinct value counts are recorded
+	// both before and after sampling.
+	TrackLabels []string
+}
+
+// Sampler keeps a deterministic subset of series chosen by fingerprint, so
+// the same series are always kept, while recording the unsampled ground
+// truth to compare estimates against.
+type Sampler struct {
+	sync.Mutex
+	keepPercent   float64
+	threshold     uint64
+	trackLabels   []string
+	seriesSeen    map[uint64]struct{}
+	seriesKept    map[uint64]struct{}
+	samplesSeen   int64
+	samplesKept   int64
+	valuesActual  map[string]map[string]struct{}
+	valuesSampled map[string]map[string]struct{}
+}
+
+func NewSampler(opts SamplerOptions) (*Sampler, error) {
+	if opts.KeepPercent <= 0 || opts.KeepPercent > 1 {
+		return nil, fmt.Errorf(
+			"keepPercent not between (0.0,1.0]: value=%v", opts.KeepPercent)
+	}
+
+	threshold := uint64(math.MaxUint64)
+	if opts.KeepPercent < 1 {
+		threshold = uint64(opts.KeepPercent * float64(math.MaxUint64))
+	}
+
+	s := &Sampler{
+		keepPercent:   opts.KeepPercent,
+		threshold:     threshold,
+		trackLabels:   opts.TrackLabels,
+		seriesSeen:    make(map[uint64]struct{}),
+		seriesKept:    make(map[uint64]struct{}),
+		valuesActual:  make(map[string]map[string]struct{}),
+		valuesSampled: make(map[string]map[string]struct{}),
+	}
+	for _, name := range opts.TrackLabels {
+		s.valuesActual[name] = make(map[string]struct{})
+		s.valuesSampled[name] = make(map[string]struct{})
+	}
+	return s, nil
+}
+
+func (s *Sampler) Sample(series []prompb.TimeSeries) []prompb.TimeSeries {
+	s.Lock()
+	defer s.Unlock()
+
+	result := series[:0:0]
+	for _, ts := range series {
+		lset := make(labels.Labels, 0, len(ts.Labels))
+		for _, l := range ts.Labels {
+			lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+		}
+		fingerprint := labels.New(lset...).Hash()
+		keep := fingerprint <= s.threshold
+
+		s.seriesSeen[fingerprint] = struct{}{}
+		s.samplesSeen += int64(len(ts.Samples))
+		s.trackWithLock(s.valuesActual, lset)
+		if !keep {
+			continue
+		}
+
+		s.seriesKept[fingerprint] = struct{}{}
+		s.samplesKept += int64(len(ts.Samples))
+		s.trackWithLock(s.valuesSampled, lset)
+		result = append(result, ts)
+	}
+	return result
+}
+
+func (s *Sampler) trackWithLock(
+	values map[string]map[string]struct{},
+	lset labels.Labels,
+) {
+	for _, name := range s.trackLabels {
+		if v := lset.Get(name); v != "" {
+			values[name][v] = struct{}{}
+		}
+	}
+}
+
+type SamplerStats struct {
+	SeriesSeen  int
+	SeriesKept  int
+	SamplesSeen int64
+	SamplesKept int64
+	// EstimatedSeries is the series count extrapolated from the kept
+	// series, to compare against the SeriesSeen ground truth.
+	EstimatedSeries float64
+	LabelValues     map[string]LabelValueCounts
+}
+
+type LabelValueCounts struct {
+	Actual  int
+	Sampled int
+}
+
+func (s *Sampler) Stats() SamplerStats {
+	s.Lock()
+	defer s.Unlock()
+
+	labelValues := make(map[string]LabelValueCounts, len(s.trackLabels))
+	for _, name := range s.trackLabels {
+		labelValues[name] = LabelValueCounts{
+			Actual:  len(s.valuesActual[name]),
+			Sampled: len(s.valuesSampled[name]),
+		}
+	}
+	return SamplerStats{
+		SeriesSeen:      len(s.seriesSeen),
+		SeriesKept:      len(s.seriesKept),
+		SamplesSeen:     s.samplesSeen,
+		SamplesKept:     s.samplesKept,
+		EstimatedSeries: float64(len(s.seriesKept)) / s.keepPercent,
+		LabelValues:     labelValues,
+	}
+}
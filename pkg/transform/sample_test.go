@@ -0,0 +1,76 @@
+package transform
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestSamplerKeepsSameSeries(t *testing.T) {
+	s, err := NewSampler(SamplerOptions{KeepPercent: 0.25, TrackLabels: []string{"host"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var series []prompb.TimeSeries
+	for i := 0; i < 1000; i++ {
+		series = append(series, testSeries("cpu", strconv.Itoa(i),
+			prompb.Sample{Timestamp: 1000, Value: 1}))
+	}
+	first := s.Sample(series)
+	second := s.Sample(series)
+	if len(first) != len(second) {
+		t.Fatalf("different series kept: first=%d, second=%d", len(first), len(second))
+	}
+	for i := range first {
+		if seriesHost(first[i]) != seriesHost(second[i]) {
+			t.Fatalf("different series kept: first=%v, second=%v", first[i].Labels, second[i].Labels)
+		}
+	}
+	if n := len(first); n < 200 || n > 300 {
+		t.Errorf("kept fraction far from the keep percent: kept=%d", n)
+	}
+
+	stats := s.Stats()
+	if stats.SeriesSeen != 1000 || stats.SeriesKept != len(first) ||
+		stats.SamplesSeen != 2000 || stats.SamplesKept != int64(2*len(first)) {
+		t.Errorf("unexpected stats: stats=%+v", stats)
+	}
+	if math.Abs(stats.EstimatedSeries-float64(len(first))/0.25) > 1e-9 {
+		t.Errorf("unexpected estimate: estimate=%v", stats.EstimatedSeries)
+	}
+	if counts := stats.LabelValues["host"]; counts.Actual != 1000 || counts.Sampled != len(first) {
+		t.Errorf("unexpected label value counts: counts=%+v", counts)
+	}
+}
+
+func TestSamplerKeepPercent(t *testing.T) {
+	for _, keepPercent := range []float64{0, -0.5, 1.5} {
+		if _, err := NewSampler(SamplerOptions{KeepPercent: keepPercent}); err == nil {
+			t.Errorf("expected invalid keep percent error: value=%v", keepPercent)
+		}
+	}
+
+	s, err := NewSampler(SamplerOptions{KeepPercent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := []prompb.TimeSeries{
+		testSeries("cpu", "a", prompb.Sample{}),
+		testSeries("cpu", "b", prompb.Sample{}),
+	}
+	if kept := s.Sample(series); len(kept) != len(series) {
+		t.Errorf("series dropped when keeping all: kept=%d", len(kept))
+	}
+}
+
+func seriesHost(s prompb.TimeSeries) string {
+	for _, l := range s.Labels {
+		if l.Name == "host" {
+			return l.Value
+		}
+	}
+	return ""
+}
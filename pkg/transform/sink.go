@@ -0,0 +1,182 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/chronosphereiox/high_cardinality_microbenchmark/pkg/sink"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var aggregationTypes = map[string]AggregationType{
+	"sum":   AggregationSum,
+	"count": AggregationCount,
+	"min":   AggregationMin,
+	"max":   AggregationMax,
+}
+
+// SinkOptions are the stages applied to series before they are written,
+// each disabled when nil.
+type SinkOptions struct {
+	Relabeler  *Relabeler
+	Sampler    *Sampler
+	Aggregator *Aggregator
+}
+
+// Enabled returns whether any stage is configured.
+func (o SinkOptions) Enabled() bool {
+	return o.Relabeler != nil || o.Sampler != nil || o.Aggregator != nil
+}
+
+// ParseSinkOptions creates the stages configured by sink params:
+// relabel_config, the path to a YAML list of relabel configs,
+// sample_keep_percent and sample_track_labels, and aggregate_drop_labels,
+// aggregate_type, one of sum, count, min or max, and aggregate_keep_raw.
+// Label lists are comma separated.
+func ParseSinkOptions(params map[string]string) (SinkOptions, error) {
+	var opts SinkOptions
+	if path, ok := params["relabel_config"]; ok {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return SinkOptions{}, fmt.Errorf("unable to read relabel config: path=%s, err=%v",
+				path, err)
+		}
+		configs, err := ParseRelabelConfigs(data)
+		if err != nil {
+			return SinkOptions{}, fmt.Errorf("invalid relabel config: path=%s, err=%v",
+				path, err)
+		}
+		if opts.Relabeler, err = NewRelabeler(configs); err != nil {
+			return SinkOptions{}, fmt.Errorf("invalid relabel config: path=%s, err=%v",
+				path, err)
+		}
+	}
+
+	if str, ok := params["sample_keep_percent"]; ok {
+		keepPercent, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return SinkOptions{}, fmt.Errorf("invalid sink param: name=sample_keep_percent, value=%s, err=%v",
+				str, err)
+		}
+		opts.Sampler, err = NewSampler(SamplerOptions{
+			KeepPercent: keepPercent,
+			TrackLabels: labelNames(params["sample_track_labels"]),
+		})
+		if err != nil {
+			return SinkOptions{}, fmt.Errorf("invalid sink param: name=sample_keep_percent, value=%s, err=%v",
+				str, err)
+		}
+	} else if str, ok := params["sample_track_labels"]; ok {
+		return SinkOptions{}, fmt.Errorf("sample track labels without a keep percent: value=%s", str)
+	}
+
+	if str, ok := params["aggregate_drop_labels"]; ok {
+		aggOpts := AggregatorOptions{DropLabels: labelNames(str)}
+		if name, ok := params["aggregate_type"]; ok {
+			if aggOpts.Type, ok = aggregationTypes[name]; !ok {
+				return SinkOptions{}, fmt.Errorf("unknown aggregation type: value=%s, supported=%v",
+					name, []string{"sum", "count", "min", "max"})
+			}
+		}
+		if keepRaw, ok := params["aggregate_keep_raw"]; ok {
+			v, err := strconv.ParseBool(keepRaw)
+			if err != nil {
+				return SinkOptions{}, fmt.Errorf("invalid sink param: name=aggregate_keep_raw, value=%s, err=%v",
+					keepRaw, err)
+			}
+			aggOpts.KeepRaw = v
+		}
+		var err error
+		if opts.Aggregator, err = NewAggregator(aggOpts); err != nil {
+			return SinkOptions{}, fmt.Errorf("invalid sink param: name=aggregate_drop_labels, value=%s, err=%v",
+				str, err)
+		}
+	}
+	return opts, nil
+}
+
+func labelNames(str string) []string {
+	var names []string
+	for _, name := range strings.Split(str, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SinkStats are the stats of each configured stage.
+type SinkStats struct {
+	Relabel   *RelabelerStats
+	Sample    *SamplerStats
+	Aggregate *AggregatorStats
+}
+
+// Sink relabels, samples and then aggregates series before writing them to
+// the next sink, in front of the backend as a collector or streaming
+// aggregation tier would be. Aggregated series are only written on Flush,
+// which callers do at the end of every generation round.
+type Sink struct {
+	next sink.Sink
+	opts SinkOptions
+}
+
+var _ sink.Sink = (*Sink)(nil)
+
+func NewSink(next sink.Sink, opts SinkOptions) *Sink {
+	return &Sink{next: next, opts: opts}
+}
+
+func (s *Sink) Write(ctx context.Context, batch []prompb.TimeSeries) error {
+	if s.opts.Relabeler != nil {
+		batch = s.opts.Relabeler.Apply(batch)
+	}
+	if s.opts.Sampler != nil {
+		batch = s.opts.Sampler.Sample(batch)
+	}
+	if s.opts.Aggregator != nil {
+		batch = s.opts.Aggregator.Add(batch)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.next.Write(ctx, batch)
+}
+
+// Flush writes the series aggregated since the last Flush before flushing
+// the next sink.
+func (s *Sink) Flush(ctx context.Context) error {
+	if s.opts.Aggregator != nil {
+		if batch := s.opts.Aggregator.Flush(); len(batch) > 0 {
+			if err := s.next.Write(ctx, batch); err != nil {
+				return err
+			}
+		}
+	}
+	return s.next.Flush(ctx)
+}
+
+func (s *Sink) Close() error {
+	return s.next.Close()
+}
+
+func (s *Sink) Stats() SinkStats {
+	var stats SinkStats
+	if s.opts.Relabeler != nil {
+		relabel := s.opts.Relabeler.Stats()
+		stats.Relabel = &relabel
+	}
+	if s.opts.Sampler != nil {
+		sample := s.opts.Sampler.Stats()
+		stats.Sample = &sample
+	}
+	if s.opts.Aggregator != nil {
+		aggregate := s.opts.Aggregator.Stats()
+		stats.Aggregate = &aggregate
+	}
+	return stats
+}
@@ -0,0 +1,89 @@
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// recordingSink records the writes and flushes made to it.
+type recordingSink struct {
+	writes  [][]prompb.TimeSeries
+	flushes int
+}
+
+func (s *recordingSink) Write(ctx context.Context, batch []prompb.TimeSeries) error {
+	s.writes = append(s.writes, batch)
+	return nil
+}
+
+func (s *recordingSink) Flush(ctx context.Context) error {
+	s.flushes++
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func TestSinkWritesAggregatesOnFlush(t *testing.T) {
+	opts, err := ParseSinkOptions(map[string]string{
+		"aggregate_drop_labels": "host",
+		"aggregate_type":        "count",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &recordingSink{}
+	s := NewSink(next, opts)
+
+	ctx := context.Background()
+	for _, host := range []string{"a", "b", "c"} {
+		err := s.Write(ctx, []prompb.TimeSeries{
+			testSeries("cpu", host, prompb.Sample{Timestamp: 1000, Value: 1}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(next.writes) != 0 {
+		t.Fatalf("aggregates written before flush: writes=%v", next.writes)
+	}
+
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.writes) != 1 || len(next.writes[0]) != 1 || next.flushes != 1 {
+		t.Fatalf("expected one aggregate written then flushed: writes=%v, flushes=%d",
+			next.writes, next.flushes)
+	}
+	if v := next.writes[0][0].Samples[0].Value; v != 3 {
+		t.Errorf("unexpected count: value=%v", v)
+	}
+}
+
+func TestParseSinkOptionsErrors(t *testing.T) {
+	tests := []map[string]string{
+		{"sample_keep_percent": "0"},
+		{"sample_keep_percent": "x"},
+		{"sample_track_labels": "host"},
+		{"aggregate_drop_labels": "host", "aggregate_type": "avg"},
+		{"aggregate_drop_labels": "host", "aggregate_keep_raw": "maybe"},
+		{"aggregate_drop_labels": ","},
+		{"relabel_config": "/nonexistent/relabel.yaml"},
+	}
+	for _, params := range tests {
+		if _, err := ParseSinkOptions(params); err == nil {
+			t.Errorf("expected invalid sink params error: params=%v", params)
+		}
+	}
+
+	opts, err := ParseSinkOptions(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Enabled() {
+		t.Errorf("stages enabled without params: opts=%+v", opts)
+	}
+}
@@ -0,0 +1,241 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const azureAPIVersion = "2020-04-08"
+
+type AzureBucketOptions struct {
+	Account    string
+	AccountKey string
+	Container  string
+	// Prefix is prepended to all blob names.
+	Prefix string
+	// Endpoint is the blob service base URL, defaults to
+	// https://<account>.blob.core.windows.net.
+	Endpoint string
+	// BlockSize is the size above which blobs are uploaded as blocks of
+	// this size.
+	BlockSize int
+	// BlockConcurrency is the number of blocks of a blob uploaded in
+	// parallel.
+	BlockConcurrency int
+	Client           *http.Client
+}
+
+// AzureBucket uploads block blobs to Azure Blob Storage using Shared Key
+// authorization.
+type AzureBucket struct {
+	opts   AzureBucketOptions
+	base   *url.URL
+	key    []byte
+	client *http.Client
+}
+
+func NewAzureBucket(opts AzureBucketOptions) (*AzureBucket, error) {
+	if opts.Account == "" || opts.Container == "" {
+		return nil, fmt.Errorf("no azure account or container: account=%s, container=%s",
+			opts.Account, opts.Container)
+	}
+	key, err := base64.StdEncoding.DecodeString(opts.AccountKey)
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid azure account key: account=%s", opts.Account)
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = defaultPartSize
+	}
+	if opts.BlockConcurrency <= 0 {
+		opts.BlockConcurrency = defaultPartConcurrency
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", opts.Account)
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse azure endpoint: endpoint=%s, err=%v",
+			endpoint, err)
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &AzureBucket{
+		opts:   opts,
+		base:   base,
+		key:    key,
+		client: client,
+	}, nil
+}
+
+func (b *AzureBucket) Name() string {
+	return "azure:" + b.opts.Account + "/" + b.opts.Container
+}
+
+func (b *AzureBucket) Upload(
+	ctx context.Context,
+	name string,
+	r io.Reader,
+	size int64,
+) error {
+	blob := path.Join(b.opts.Prefix, name)
+	if size <= int64(b.opts.BlockSize) {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}}
+		return b.do(ctx, blob, nil, header, data)
+	}
+
+	var blockIDs []string
+	parts, err := uploadParts(ctx, r, b.opts.BlockSize, b.opts.BlockConcurrency,
+		func(ctx context.Context, partNumber int, data []byte) error {
+			query := url.Values{
+				"comp":    {"block"},
+				"blockid": {azureBlockID(partNumber)},
+			}
+			return b.do(ctx, blob, query, nil, data)
+		})
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= parts; i++ {
+		blockIDs = append(blockIDs, azureBlockID(i))
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: blockIDs})
+	if err != nil {
+		return err
+	}
+	return b.do(ctx, blob, url.Values{"comp": {"blocklist"}}, nil,
+		append([]byte(xml.Header), body...))
+}
+
+// azureBlockID returns a block ID, which must be base64 and the same length
+// for all blocks of a blob.
+func azureBlockID(partNumber int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", partNumber)))
+}
+
+func (b *AzureBucket) do(
+	ctx context.Context,
+	blob string,
+	query url.Values,
+	header http.Header,
+	body []byte,
+) error {
+	u := *b.base
+	u.Path = path.Join("/", b.base.Path, b.opts.Container, blob)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureAPIVersion)
+	b.sign(req)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("azure request failed: blob=%s, status=%d, body=%s",
+			blob, resp.StatusCode, msg)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// sign adds the Shared Key Authorization header.
+func (b *AzureBucket) sign(req *http.Request) {
+	h := hmac.New(sha256.New, b.key)
+	h.Write([]byte(azureStringToSign(req, b.opts.Account)))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	req.Header.Set("Authorization", "SharedKey "+b.opts.Account+":"+signature)
+}
+
+// azureStringToSign returns the Shared Key string to sign of a request to
+// the Blob service of the account.
+func azureStringToSign(req *http.Request, account string) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var msHeaders []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower)
+		}
+	}
+	sort.Strings(msHeaders)
+
+	var canonical strings.Builder
+	for _, name := range msHeaders {
+		canonical.WriteString(name)
+		canonical.WriteByte(':')
+		canonical.WriteString(strings.TrimSpace(req.Header.Get(name)))
+		canonical.WriteByte('\n')
+	}
+
+	canonical.WriteString("/" + account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+		canonical.WriteString("\n" + strings.ToLower(name) + ":" +
+			strings.Join(values, ","))
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonical.String(),
+	}, "\n")
+}
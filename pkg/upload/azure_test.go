@@ -0,0 +1,96 @@
+package upload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestAzureStringToSignExample checks the string to sign of the Shared Key
+// example in the Azure Storage REST API reference, "Authorize with Shared
+// Key".
+func TestAzureStringToSignExample(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet,
+		"https://myaccount.blob.core.windows.net/myaccount/mycontainer?restype=container&comp=metadata&timeout=20", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Ms-Date", "Sun, 11 Oct 2009 21:49:13 GMT")
+	req.Header.Set("X-Ms-Version", "2009-09-19")
+
+	expected := "GET\n\n\n\n\n\n\n\n\n\n\n\n" +
+		"x-ms-date:Sun, 11 Oct 2009 21:49:13 GMT\n" +
+		"x-ms-version:2009-09-19\n" +
+		"/myaccount/myaccount/mycontainer\ncomp:metadata\nrestype:container\ntimeout:20"
+	if actual := azureStringToSign(req, "myaccount"); actual != expected {
+		t.Errorf("unexpected string to sign:\nexpected=%q\nactual=%q", expected, actual)
+	}
+}
+
+// TestAzureCanonicalizedResourceExamples checks the canonicalized resources
+// of the examples in the same reference, the last line of the string to
+// sign.
+func TestAzureCanonicalizedResourceExamples(t *testing.T) {
+	tests := []struct {
+		url      string
+		resource string
+	}{
+		{
+			url:      "https://myaccount.blob.core.windows.net/mycontainer?restype=container&comp=metadata",
+			resource: "/myaccount/mycontainer\ncomp:metadata\nrestype:container",
+		},
+		{
+			url: "https://myaccount.blob.core.windows.net/mycontainer?restype=container&comp=list" +
+				"&include=snapshots&include=metadata&include=uncommittedblobs",
+			resource: "/myaccount/mycontainer\ncomp:list\ninclude:metadata,snapshots,uncommittedblobs\nrestype:container",
+		},
+		{
+			url:      "https://myaccount-secondary.blob.core.windows.net/mycontainer/myblob",
+			resource: "/myaccount/mycontainer/myblob",
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stringToSign := azureStringToSign(req, "myaccount")
+		if !strings.HasSuffix(stringToSign, "\n"+test.resource) {
+			t.Errorf("unexpected canonicalized resource: url=%s, expected=%q, stringToSign=%q",
+				test.url, test.resource, stringToSign)
+		}
+	}
+}
+
+func TestAzureSignContentLength(t *testing.T) {
+	key := []byte("example account key")
+	b := &AzureBucket{
+		opts: AzureBucketOptions{Account: "myaccount"},
+		key:  key,
+	}
+	req, err := http.NewRequest(http.MethodPut,
+		"https://myaccount.blob.core.windows.net/mycontainer/myblob",
+		strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	req.Header.Set("X-Ms-Date", "Fri, 26 Jun 2015 23:39:12 GMT")
+	req.Header.Set("X-Ms-Version", "2015-02-21")
+	b.sign(req)
+
+	stringToSign := "PUT\n\n\n5\n\n\n\n\n\n\n\n\n" +
+		"x-ms-blob-type:BlockBlob\n" +
+		"x-ms-date:Fri, 26 Jun 2015 23:39:12 GMT\n" +
+		"x-ms-version:2015-02-21\n" +
+		"/myaccount/mycontainer/myblob"
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+	expected := "SharedKey myaccount:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if actual := req.Header.Get("Authorization"); actual != expected {
+		t.Errorf("unexpected authorization: expected=%s, actual=%s", expected, actual)
+	}
+}
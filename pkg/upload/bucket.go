@@ -0,0 +1,71 @@
+package upload
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// NewBucketFromURL returns the bucket addressed by a URL, one of:
+//
+//	file:///data/blocks
+//	s3://bucket/prefix?region=us-west-2&endpoint=http://minio:9000
+//	gs://bucket/prefix
+//	azure://account/container/prefix
+//
+// Credentials are read from the environment: AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN for S3, GCS_HMAC_ACCESS_KEY_ID
+// and GCS_HMAC_SECRET for GCS and AZURE_STORAGE_KEY for Azure.
+func NewBucketFromURL(rawURL string) (Bucket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse bucket URL: url=%s, err=%v",
+			rawURL, err)
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "file":
+		return NewFilesystemBucket(u.Path), nil
+	case "s3":
+		region := u.Query().Get("region")
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		return NewS3Bucket(S3BucketOptions{
+			Bucket:       u.Host,
+			Prefix:       prefix,
+			Endpoint:     u.Query().Get("endpoint"),
+			Region:       region,
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		})
+	case "gs":
+		// GCS is addressed through its S3 compatible XML API
+		return NewS3Bucket(S3BucketOptions{
+			Bucket:    u.Host,
+			Prefix:    prefix,
+			Endpoint:  "https://storage.googleapis.com",
+			Region:    "auto",
+			AccessKey: os.Getenv("GCS_HMAC_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("GCS_HMAC_SECRET"),
+		})
+	case "azure":
+		parts := strings.SplitN(prefix, "/", 2)
+		container, blobPrefix := parts[0], ""
+		if len(parts) == 2 {
+			blobPrefix = parts[1]
+		}
+		return NewAzureBucket(AzureBucketOptions{
+			Account:    u.Host,
+			AccountKey: os.Getenv("AZURE_STORAGE_KEY"),
+			Container:  container,
+			Prefix:     blobPrefix,
+			Endpoint:   u.Query().Get("endpoint"),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported bucket URL scheme: url=%s", rawURL)
+	}
+}
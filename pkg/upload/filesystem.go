@@ -0,0 +1,44 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FilesystemBucket stores objects under a local directory, matching the
+// layout of the Thanos filesystem object store.
+type FilesystemBucket struct {
+	dir string
+}
+
+func NewFilesystemBucket(dir string) *FilesystemBucket {
+	return &FilesystemBucket{dir: dir}
+}
+
+func (b *FilesystemBucket) Upload(
+	ctx context.Context,
+	name string,
+	r io.Reader,
+	size int64,
+) error {
+	file := filepath.Join(b.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (b *FilesystemBucket) Name() string {
+	return "filesystem:" + b.dir
+}
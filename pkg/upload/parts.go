@@ -0,0 +1,86 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+const (
+	defaultPartSize        = 16 << 20
+	defaultPartConcurrency = 4
+)
+
+type uploadPartFn func(ctx context.Context, partNumber int, data []byte) error
+
+// uploadParts splits the reader into parts uploaded in parallel, at most
+// concurrency parts are buffered in memory at a time. Part numbers start at
+// one. Returns the number of parts uploaded.
+func uploadParts(
+	ctx context.Context,
+	r io.Reader,
+	partSize int,
+	concurrency int,
+	fn uploadPartFn,
+) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+		parts    int
+	)
+	setErr := func(err error) {
+		lock.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		lock.Unlock()
+	}
+
+	for {
+		buf := make([]byte, partSize)
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			setErr(err)
+			break
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		parts++
+		wg.Add(1)
+		go func(partNumber int, data []byte) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(ctx, partNumber, data); err != nil {
+				setErr(err)
+			}
+		}(parts, buf[:n])
+
+		if n < partSize {
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return parts, firstErr
+	}
+	return parts, ctx.Err()
+}
@@ -0,0 +1,362 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+	s3DateFormat      = "20060102"
+)
+
+type S3BucketOptions struct {
+	Bucket string
+	// Prefix is prepended to all object names.
+	Prefix string
+	// Endpoint is the base URL of an S3 compatible service, defaults to
+	// AWS S3 for the region. Custom endpoints use path style addressing.
+	Endpoint     string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PartSize is the size above which objects are uploaded as multipart
+	// uploads of parts this size.
+	PartSize int
+	// PartConcurrency is the number of parts of an object uploaded in
+	// parallel.
+	PartConcurrency int
+	Client          *http.Client
+}
+
+// S3Bucket uploads to AWS S3 or any S3 compatible store, including Google
+// Cloud Storage through its XML API with HMAC keys, signing requests with
+// AWS Signature Version 4.
+type S3Bucket struct {
+	opts      S3BucketOptions
+	base      *url.URL
+	pathStyle bool
+	client    *http.Client
+}
+
+func NewS3Bucket(opts S3BucketOptions) (*S3Bucket, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("no s3 bucket")
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, fmt.Errorf("no s3 credentials: bucket=%s", opts.Bucket)
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaultPartSize
+	}
+	if opts.PartConcurrency <= 0 {
+		opts.PartConcurrency = defaultPartConcurrency
+	}
+
+	endpoint := opts.Endpoint
+	pathStyle := endpoint != ""
+	if !pathStyle {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com",
+			opts.Bucket, opts.Region)
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse s3 endpoint: endpoint=%s, err=%v",
+			endpoint, err)
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3Bucket{
+		opts:      opts,
+		base:      base,
+		pathStyle: pathStyle,
+		client:    client,
+	}, nil
+}
+
+func (b *S3Bucket) Name() string {
+	return "s3:" + b.opts.Bucket
+}
+
+func (b *S3Bucket) Upload(
+	ctx context.Context,
+	name string,
+	r io.Reader,
+	size int64,
+) error {
+	key := path.Join(b.opts.Prefix, name)
+	if size <= int64(b.opts.PartSize) {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		_, err = b.do(ctx, http.MethodPut, key, nil, data)
+		return err
+	}
+	return b.uploadMultipart(ctx, key, r)
+}
+
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+func (b *S3Bucket) uploadMultipart(ctx context.Context, key string, r io.Reader) error {
+	resp, err := b.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiated s3InitiateMultipartUploadResult
+	if err := xml.Unmarshal(resp, &initiated); err != nil {
+		return fmt.Errorf("unable to parse s3 multipart upload: key=%s, err=%v",
+			key, err)
+	}
+
+	var (
+		lock      sync.Mutex
+		completed []s3CompletedPart
+	)
+	_, err = uploadParts(ctx, r, b.opts.PartSize, b.opts.PartConcurrency,
+		func(ctx context.Context, partNumber int, data []byte) error {
+			query := url.Values{
+				"partNumber": {strconv.Itoa(partNumber)},
+				"uploadId":   {initiated.UploadID},
+			}
+			etag, err := b.doETag(ctx, http.MethodPut, key, query, data)
+			if err != nil {
+				return err
+			}
+			lock.Lock()
+			completed = append(completed, s3CompletedPart{
+				PartNumber: partNumber,
+				ETag:       etag,
+			})
+			lock.Unlock()
+			return nil
+		})
+	if err != nil {
+		abortQuery := url.Values{"uploadId": {initiated.UploadID}}
+		b.do(context.Background(), http.MethodDelete, key, abortQuery, nil)
+		return err
+	}
+
+	sort.Slice(completed, func(i, j int) bool {
+		return completed[i].PartNumber < completed[j].PartNumber
+	})
+	body, err := xml.Marshal(s3CompleteMultipartUpload{Parts: completed})
+	if err != nil {
+		return err
+	}
+	_, err = b.do(ctx, http.MethodPost, key,
+		url.Values{"uploadId": {initiated.UploadID}}, body)
+	return err
+}
+
+func (b *S3Bucket) do(
+	ctx context.Context,
+	method, key string,
+	query url.Values,
+	body []byte,
+) ([]byte, error) {
+	resp, err := b.roundTrip(ctx, method, key, query, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (b *S3Bucket) doETag(
+	ctx context.Context,
+	method, key string,
+	query url.Values,
+	body []byte,
+) (string, error) {
+	resp, err := b.roundTrip(ctx, method, key, query, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.Header.Get("ETag"), nil
+}
+
+func (b *S3Bucket) roundTrip(
+	ctx context.Context,
+	method, key string,
+	query url.Values,
+	body []byte,
+) (*http.Response, error) {
+	u := *b.base
+	objectPath := "/" + key
+	if b.pathStyle {
+		objectPath = path.Join("/", strings.TrimSuffix(b.base.Path, "/"),
+			b.opts.Bucket, key)
+	}
+	u.Path = objectPath
+	u.RawPath = s3EscapePath(objectPath)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(body))
+	if b.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.opts.SessionToken)
+	}
+	signS3Request(req, b.opts.Region, b.opts.AccessKey, b.opts.SecretKey,
+		s3UnsignedPayload, time.Now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 request failed: method=%s, key=%s, status=%d, body=%s",
+			method, key, resp.StatusCode, msg)
+	}
+	return resp, nil
+}
+
+// signS3Request adds an AWS Signature Version 4 Authorization header for
+// the S3 service, signing the host, content type, range and x-amz-* headers.
+func signS3Request(
+	req *http.Request,
+	region, accessKey, secretKey, payloadHash string,
+	now time.Time,
+) {
+	now = now.UTC()
+	amzDate := now.Format(s3TimeFormat)
+	date := now.Format(s3DateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" ||
+			lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name)
+		canonicalHeaders.WriteByte(':')
+		canonicalHeaders.WriteString(headers[name])
+		canonicalHeaders.WriteByte('\n')
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3EscapePath URI encodes everything but unreserved characters and the
+// path separator, as required by Signature Version 4.
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3Escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,218 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const metaFilename = "meta.json"
+
+// Bucket is an object store blocks are uploaded to.
+type Bucket interface {
+	Upload(ctx context.Context, name string, r io.Reader, size int64) error
+	Name() string
+}
+
+type BlockOptions struct {
+	// Prefix is prepended to object names, e.g. the tenant ID for Cortex
+	// and Mimir which expect blocks under <tenant>/<block ULID>/.
+	Prefix string
+	// ExternalLabels are written to the Thanos section of meta.json.
+	ExternalLabels map[string]string
+	// Source is the Thanos source type recorded in meta.json, defaults to
+	// "sidecar" as generated blocks are raw two hour blocks.
+	Source string
+	// Concurrency is the number of files uploaded in parallel.
+	Concurrency int
+}
+
+const (
+	defaultSource      = "sidecar"
+	defaultConcurrency = 4
+)
+
+type Stats struct {
+	Files    int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// BytesPerSecond returns the upload throughput.
+func (s Stats) BytesPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+type thanosMeta struct {
+	Labels     map[string]string `json:"labels"`
+	Downsample struct {
+		Resolution int64 `json:"resolution"`
+	} `json:"downsample"`
+	Source string `json:"source"`
+}
+
+// UploadBlock uploads a Prometheus TSDB block directory with a meta.json
+// carrying the Thanos external labels, so Thanos, Cortex and Mimir store
+// gateways can serve it. The meta.json is uploaded last so that the block
+// never appears partially uploaded.
+func UploadBlock(
+	ctx context.Context,
+	bucket Bucket,
+	blockDir string,
+	opts BlockOptions,
+) (Stats, error) {
+	start := time.Now()
+	blockID := filepath.Base(filepath.Clean(blockDir))
+
+	meta, err := blockMeta(blockDir, opts)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var files []string
+	err = filepath.Walk(blockDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() == metaFilename {
+			return nil
+		}
+		rel, err := filepath.Rel(blockDir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return Stats{}, fmt.Errorf("unable to list block files: dir=%s, err=%v",
+			blockDir, err)
+	}
+	sort.Strings(files)
+
+	concurrency := defaultConcurrency
+	if opts.Concurrency > 0 {
+		concurrency = opts.Concurrency
+	}
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		stats    Stats
+		firstErr error
+		work     = make(chan string)
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range work {
+				lock.Lock()
+				failed := firstErr != nil
+				lock.Unlock()
+				if failed {
+					continue
+				}
+
+				n, err := uploadFile(ctx, bucket, filepath.Join(blockDir, rel),
+					objectName(opts.Prefix, blockID, rel))
+				lock.Lock()
+				switch {
+				case err == nil:
+					stats.Files++
+					stats.Bytes += n
+				case firstErr == nil:
+					firstErr = err
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, rel := range files {
+		work <- rel
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return stats, firstErr
+	}
+
+	name := objectName(opts.Prefix, blockID, metaFilename)
+	if err := bucket.Upload(ctx, name, bytes.NewReader(meta), int64(len(meta))); err != nil {
+		return stats, fmt.Errorf("unable to upload block meta: name=%s, err=%v",
+			name, err)
+	}
+	stats.Files++
+	stats.Bytes += int64(len(meta))
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// blockMeta returns the block's meta.json with the Thanos section added,
+// leaving all other fields untouched.
+func blockMeta(blockDir string, opts BlockOptions) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(blockDir, metaFilename))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read block meta: dir=%s, err=%v",
+			blockDir, err)
+	}
+
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("unable to parse block meta: dir=%s, err=%v",
+			blockDir, err)
+	}
+
+	thanos := thanosMeta{
+		Labels: opts.ExternalLabels,
+		Source: defaultSource,
+	}
+	if thanos.Labels == nil {
+		thanos.Labels = map[string]string{}
+	}
+	if opts.Source != "" {
+		thanos.Source = opts.Source
+	}
+	raw, err := json.Marshal(thanos)
+	if err != nil {
+		return nil, err
+	}
+	meta["thanos"] = raw
+
+	return json.MarshalIndent(meta, "", "\t")
+}
+
+func uploadFile(ctx context.Context, bucket Bucket, file, name string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := bucket.Upload(ctx, name, f, info.Size()); err != nil {
+		return 0, fmt.Errorf("unable to upload block file: name=%s, err=%v",
+			name, err)
+	}
+	return info.Size(), nil
+}
+
+func objectName(prefix, blockID, rel string) string {
+	return path.Join(prefix, blockID, filepath.ToSlash(rel))
+}
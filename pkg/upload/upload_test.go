@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// failingBucket fails uploads of objects whose name contains fail.
+type failingBucket struct {
+	sync.Mutex
+	fail     string
+	uploaded []string
+}
+
+func (b *failingBucket) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	if strings.Contains(name, b.fail) {
+		return errors.New("upload failed")
+	}
+	b.Lock()
+	b.uploaded = append(b.uploaded, name)
+	b.Unlock()
+	return nil
+}
+
+func (b *failingBucket) Name() string {
+	return "failing"
+}
+
+func TestUploadBlockCountsOnlyUploadedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blockDir := filepath.Join(dir, "01BLOCK")
+	files := map[string]string{
+		metaFilename:                          `{"ulid":"01BLOCK","version":1}`,
+		"index":                               "index",
+		filepath.Join("chunks", "000001"):     "chunk 1",
+		filepath.Join("chunks", "000002_bad"): "chunk 2",
+	}
+	for name, data := range files {
+		p := filepath.Join(blockDir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bucket := &failingBucket{fail: "_bad"}
+	stats, err := UploadBlock(context.Background(), bucket, blockDir,
+		BlockOptions{Concurrency: 1})
+	if err == nil {
+		t.Fatal("expected upload error")
+	}
+	if stats.Files != len(bucket.uploaded) {
+		t.Errorf("files not counted as uploaded: files=%d, uploaded=%v",
+			stats.Files, bucket.uploaded)
+	}
+	for _, name := range bucket.uploaded {
+		if strings.HasSuffix(name, metaFilename) {
+			t.Errorf("meta uploaded after a failed upload: name=%s", name)
+		}
+	}
+}